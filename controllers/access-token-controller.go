@@ -0,0 +1,148 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+    "github.com/gorilla/mux"
+)
+
+func ListPersonalAccessTokens(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        tokens, err := models.GetPersonalAccessTokensByUser(db, user.ID)
+        if err != nil {
+            fmt.Println("Error fetching access tokens:", err)
+            http.Error(w, "Error fetching tokens", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
+
+func CreatePersonalAccessToken(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Name          string   `json:"name"`
+            Scopes        []string `json:"scopes"`
+            ExpiresInDays int      `json:"expires_in_days"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+
+        if req.Name == "" || len(req.Name) > 100 {
+            http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+            return
+        }
+        if len(req.Scopes) == 0 {
+            http.Error(w, "At least one scope is required", http.StatusBadRequest)
+            return
+        }
+        for _, scope := range req.Scopes {
+            if !validScope(scope) {
+                http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+                return
+            }
+        }
+        if req.ExpiresInDays < 0 {
+            http.Error(w, "expires_in_days must not be negative", http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        raw, prefix, err := utils.GeneratePersonalAccessToken()
+        if err != nil {
+            fmt.Println("Error generating access token:", err)
+            http.Error(w, "Error creating token", http.StatusInternalServerError)
+            return
+        }
+
+        token := &models.PersonalAccessToken{
+            UserID:      user.ID,
+            Name:        req.Name,
+            TokenPrefix: prefix,
+            TokenHash:   utils.HashToken(raw),
+            Scopes:      req.Scopes,
+        }
+        // Zero means the token never expires
+        if req.ExpiresInDays > 0 {
+            expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+            token.ExpiresAt = &expiresAt
+        }
+
+        err = models.CreatePersonalAccessToken(db, token)
+        if err != nil {
+            fmt.Println("Error storing access token:", err)
+            http.Error(w, "Error creating token", http.StatusInternalServerError)
+            return
+        }
+
+        // The raw token is only ever returned here
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(struct {
+            *models.PersonalAccessToken
+            Token string `json:"token"`
+        }{token, raw})
+    }
+}
+
+func RevokePersonalAccessToken(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        id, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid token ID", http.StatusBadRequest)
+            return
+        }
+
+        err = models.RevokePersonalAccessToken(db, user.ID, id)
+        if err == models.ErrPersonalAccessTokenNotFound {
+            http.Error(w, "Token not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error revoking access token:", err)
+            http.Error(w, "Error revoking token", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked successfully"})
+    }
+}
+
+func validScope(scope string) bool {
+    for _, s := range models.PersonalAccessTokenScopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
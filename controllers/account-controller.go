@@ -0,0 +1,237 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+// Accounts are only removed after a grace period, during which the deletion can be cancelled
+func accountDeletionGracePeriod() time.Duration {
+    return utils.GetEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour)
+}
+
+// ExportPersonalData returns everything stored about the user as a JSON download
+func ExportPersonalData(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        export, err := collectPersonalData(db, user)
+        if err != nil {
+            fmt.Println("Error exporting personal data:", err)
+            http.Error(w, "Error exporting data", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="blog-export-%d.json"`, user.ID))
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(export)
+    }
+}
+
+func collectPersonalData(db *sql.DB, user *models.User) (map[string]interface{}, error) {
+    roles, err := models.GetUserRoles(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    posts, err := models.GetPostsByAuthor(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    sessions, err := models.GetActiveSessionsByUser(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    passkeys, err := models.GetWebAuthnCredentialsByUser(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    tokens, err := models.GetPersonalAccessTokensByUser(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    logins, err := models.GetLoginAttemptsByEmail(db, normalizeLoginEmail(user.Email))
+    if err != nil {
+        return nil, err
+    }
+    audit, err := models.GetAuditEntriesForUser(db, user.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    var pendingDeletion *models.AccountDeletion
+    deletion, err := models.GetAccountDeletion(db, user.ID)
+    if err == nil {
+        pendingDeletion = deletion
+    } else if err != models.ErrAccountDeletionNotFound {
+        return nil, err
+    }
+
+    // Secrets such as the password hash and TOTP seed are left out on purpose
+    account := map[string]interface{}{
+        "id":                  user.ID,
+        "username":            user.Username,
+        "name":                user.Name,
+        "email":               user.Email,
+        "bio":                 user.Bio,
+        "created_at":          user.CreatedAt,
+        "email_verified_at":   user.EmailVerifiedAt,
+        "two_factor_enabled":  user.TOTPEnabledAt != nil,
+        "password_changed_at": user.PasswordChangedAt,
+    }
+
+    return map[string]interface{}{
+        "exported_at":            time.Now(),
+        "account":                account,
+        "roles":                  roles,
+        "posts":                  posts,
+        "sessions":               sessions,
+        "passkeys":               passkeys,
+        "personal_access_tokens": tokens,
+        "login_history":          logins,
+        "audit_log":              audit,
+        "pending_deletion":       pendingDeletion,
+    }, nil
+}
+
+// RequestAccountDeletion schedules the account for deletion after the grace period.
+// mode decides whether posts are moved to the anonymous author or deleted.
+func RequestAccountDeletion(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Password string `json:"password"`
+            Mode     string `json:"mode"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+        if req.Mode == "" {
+            req.Mode = models.DeletionModeAnonymize
+        }
+        if req.Mode != models.DeletionModeAnonymize && req.Mode != models.DeletionModeDelete {
+            http.Error(w, `mode must be "anonymize" or "delete"`, http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        // Accounts created through single sign-on have no password to confirm with
+        valid, _, err := utils.VerifyPassword(user.Password, req.Password)
+        if err != utils.ErrUnknownPasswordHash && (err != nil || !valid) {
+            http.Error(w, "Invalid password", http.StatusUnauthorized)
+            return
+        }
+
+        now := time.Now()
+        deletion := &models.AccountDeletion{
+            UserID:       user.ID,
+            Mode:         req.Mode,
+            RequestedAt:  now,
+            ScheduledFor: now.Add(accountDeletionGracePeriod()),
+        }
+        err = models.RequestAccountDeletion(db, deletion)
+        if err != nil {
+            fmt.Println("Error requesting account deletion:", err)
+            http.Error(w, "Error requesting account deletion", http.StatusInternalServerError)
+            return
+        }
+
+        recordAccountAudit(db, r, user, models.AuditAccountDeletionRequested,
+            fmt.Sprintf("mode %s, scheduled for %s", deletion.Mode, deletion.ScheduledFor.Format(time.RFC3339)))
+
+        // Tell the owner, in case someone else is using their login
+        body := fmt.Sprintf("Hi %s,\n\nYour account is scheduled to be deleted on %s. "+
+            "If you didn't ask for this, log in and cancel the deletion before then.\n",
+            user.Name, deletion.ScheduledFor.Format("2 January 2006 15:04 MST"))
+        err = mailer.Send(user.Email, "Your account will be deleted", body)
+        if err != nil {
+            fmt.Println("Error sending deletion email:", err)
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(deletion)
+    }
+}
+
+// GetAccountDeletion shows the pending deletion request, if any
+func GetAccountDeletion(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        deletion, err := models.GetAccountDeletion(db, user.ID)
+        if err == models.ErrAccountDeletionNotFound {
+            http.Error(w, "No account deletion is pending", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error fetching account deletion:", err)
+            http.Error(w, "Error fetching account deletion", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(deletion)
+    }
+}
+
+func CancelAccountDeletion(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        err := models.CancelAccountDeletion(db, user.ID)
+        if err == models.ErrAccountDeletionNotFound {
+            http.Error(w, "No account deletion is pending", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error cancelling account deletion:", err)
+            http.Error(w, "Error cancelling account deletion", http.StatusInternalServerError)
+            return
+        }
+
+        recordAccountAudit(db, r, user, models.AuditAccountDeletionCancelled, "")
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
+    }
+}
+
+// recordAccountAudit logs an action the user took on their own account
+func recordAccountAudit(db *sql.DB, r *http.Request, user *models.User, action, details string) {
+    err := models.RecordAudit(db, &models.AuditEntry{
+        ActorUserID:  &user.ID,
+        Action:       action,
+        TargetUserID: &user.ID,
+        IP:           utils.ClientIP(r),
+        Details:      details,
+    })
+    if err != nil {
+        fmt.Println("Error writing audit log:", err)
+    }
+}
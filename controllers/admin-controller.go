@@ -0,0 +1,487 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+    "github.com/gorilla/mux"
+)
+
+func ListRoles(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        roles, err := models.GetRoles(db)
+        if err != nil {
+            fmt.Println("Error fetching roles:", err)
+            http.Error(w, "Error fetching roles", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(roles)
+    }
+}
+
+func GetUserRoles(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        target, ok := adminTargetUser(db, w, r)
+        if !ok {
+            return
+        }
+
+        roles, err := models.GetUserRoles(db, target.ID)
+        if err != nil {
+            fmt.Println("Error fetching roles:", err)
+            http.Error(w, "Error fetching roles", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"user_id": target.ID, "roles": roles})
+    }
+}
+
+// SetUserRoles replaces a user's roles with the given list
+func SetUserRoles(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Roles []string `json:"roles"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+        if len(req.Roles) == 0 {
+            http.Error(w, "At least one role is required, use \"reader\" for read-only accounts", http.StatusBadRequest)
+            return
+        }
+
+        admin, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        target, ok := adminTargetUser(db, w, r)
+        if !ok {
+            return
+        }
+
+        // Stop admins from locking themselves out
+        if target.ID == admin.ID && !containsString(req.Roles, models.RoleAdmin) {
+            http.Error(w, "You can't remove your own admin role", http.StatusBadRequest)
+            return
+        }
+
+        err = models.SetUserRoles(db, target.ID, req.Roles, admin.ID)
+        if err == models.ErrUnknownRole {
+            http.Error(w, "Unknown role", http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error assigning roles:", err)
+            http.Error(w, "Error assigning roles", http.StatusInternalServerError)
+            return
+        }
+
+        roles, err := models.GetUserRoles(db, target.ID)
+        if err != nil {
+            fmt.Println("Error fetching roles:", err)
+            http.Error(w, "Error fetching roles", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"user_id": target.ID, "roles": roles})
+    }
+}
+
+func ListLockouts(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        lockouts, err := models.GetActiveLockouts(db, time.Now())
+        if err != nil {
+            fmt.Println("Error fetching lockouts:", err)
+            http.Error(w, "Error fetching lockouts", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(lockouts)
+    }
+}
+
+// ClearLockout lifts a lockout early, e.g. after confirming the account owner's identity
+func ClearLockout(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        admin, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        id, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid lockout ID", http.StatusBadRequest)
+            return
+        }
+
+        lockout, err := models.ClearLockout(db, id, admin.ID, time.Now())
+        if err == models.ErrLockoutNotFound {
+            http.Error(w, "Lockout not found or already expired", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error clearing lockout:", err)
+            http.Error(w, "Error clearing lockout", http.StatusInternalServerError)
+            return
+        }
+
+        entry := &models.AuditEntry{
+            ActorUserID: &admin.ID,
+            Action:      models.AuditLockoutCleared,
+            IP:          utils.ClientIP(r),
+            Details:     fmt.Sprintf("cleared %s lockout for %s", lockout.Scope, lockout.Key),
+        }
+        if lockout.Scope == models.LockoutScopeEmail {
+            if user, err := models.GetUserByEmail(db, lockout.Key); err == nil {
+                entry.TargetUserID = &user.ID
+            }
+        }
+        err = models.RecordAudit(db, entry)
+        if err != nil {
+            fmt.Println("Error writing audit log:", err)
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]string{"message": "Lockout cleared"})
+    }
+}
+
+// adminUser is how accounts are shown to admins; the password hash and TOTP secret stay private
+type adminUser struct {
+    ID               int        `json:"id"`
+    Username         string     `json:"username"`
+    Name             string     `json:"name"`
+    Email            string     `json:"email"`
+    CreatedAt        time.Time  `json:"created_at"`
+    EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+    TwoFactorEnabled bool       `json:"two_factor_enabled"`
+    Suspended        bool       `json:"suspended"`
+    SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+    SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+    SuspensionReason string     `json:"suspension_reason,omitempty"`
+}
+
+func newAdminUser(user *models.User) adminUser {
+    return adminUser{
+        ID:               user.ID,
+        Username:         user.Username,
+        Name:             user.Name,
+        Email:            user.Email,
+        CreatedAt:        user.CreatedAt,
+        EmailVerifiedAt:  user.EmailVerifiedAt,
+        TwoFactorEnabled: user.TOTPEnabledAt != nil,
+        Suspended:        user.IsSuspended(time.Now()),
+        SuspendedAt:      user.SuspendedAt,
+        SuspendedUntil:   user.SuspendedUntil,
+        SuspensionReason: user.SuspensionReason.String,
+    }
+}
+
+// ListUsers pages through users, optionally filtered with ?q= on username, name or email prefix
+func ListUsers(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        page, err := strconv.Atoi(query.Get("page"))
+        if err != nil || page < 1 {
+            page = 1
+        }
+        perPage, err := strconv.Atoi(query.Get("per_page"))
+        if err != nil || perPage < 1 {
+            perPage = 20
+        }
+        if perPage > 100 {
+            perPage = 100
+        }
+        // Pages this far out are empty anyway, and their offset would overflow
+        if page-1 > math.MaxInt32/perPage {
+            http.Error(w, "page is too large", http.StatusBadRequest)
+            return
+        }
+
+        users, total, err := models.SearchUsers(db, query.Get("q"), perPage, (page-1)*perPage)
+        if err != nil {
+            fmt.Println("Error searching users:", err)
+            http.Error(w, "Error fetching users", http.StatusInternalServerError)
+            return
+        }
+
+        response := make([]adminUser, 0, len(users))
+        for i := range users {
+            response = append(response, newAdminUser(&users[i]))
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "users":    response,
+            "page":     page,
+            "per_page": perPage,
+            "total":    total,
+        })
+    }
+}
+
+// GetUserDetails shows an account with its roles, activity and audit history
+func GetUserDetails(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        target, ok := adminTargetUser(db, w, r)
+        if !ok {
+            return
+        }
+
+        roles, err := models.GetUserRoles(db, target.ID)
+        if err != nil {
+            fmt.Println("Error fetching roles:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+        sessions, err := models.GetActiveSessionsByUser(db, target.ID)
+        if err != nil {
+            fmt.Println("Error fetching sessions:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+        posts, err := models.CountPostsByAuthor(db, target.ID)
+        if err != nil {
+            fmt.Println("Error counting posts:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+        audit, err := models.GetAuditEntriesForUser(db, target.ID)
+        if err != nil {
+            fmt.Println("Error fetching audit log:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+
+        var pendingDeletion *models.AccountDeletion
+        deletion, err := models.GetAccountDeletion(db, target.ID)
+        if err == nil {
+            pendingDeletion = deletion
+        } else if err != models.ErrAccountDeletionNotFound {
+            fmt.Println("Error fetching account deletion:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "user":             newAdminUser(target),
+            "roles":            roles,
+            "sessions":         sessions,
+            "post_count":       posts,
+            "pending_deletion": pendingDeletion,
+            "audit_log":        audit,
+        })
+    }
+}
+
+// SuspendUser blocks an account from logging in and ends all of its sessions.
+// Without "until" the suspension lasts until it is lifted.
+func SuspendUser(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Reason string     `json:"reason"`
+            Until  *time.Time `json:"until"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+        if req.Reason == "" || len([]rune(req.Reason)) > 500 {
+            http.Error(w, "A reason of at most 500 characters is required", http.StatusBadRequest)
+            return
+        }
+        if req.Until != nil && !req.Until.After(time.Now()) {
+            http.Error(w, "until must be in the future", http.StatusBadRequest)
+            return
+        }
+
+        admin, target, ok := adminActionTarget(db, w, r)
+        if !ok {
+            return
+        }
+
+        err = models.SuspendUser(db, target.ID, req.Reason, req.Until, admin.ID)
+        if err != nil {
+            fmt.Println("Error suspending user:", err)
+            http.Error(w, "Error suspending user", http.StatusInternalServerError)
+            return
+        }
+        err = models.RevokeUserRefreshTokens(db, target.ID)
+        if err != nil {
+            fmt.Println("Error revoking sessions:", err)
+        }
+
+        until := "until lifted"
+        if req.Until != nil {
+            until = "until " + req.Until.Format(time.RFC3339)
+        }
+        recordAdminAudit(db, r, admin, target, models.AuditUserSuspended, until+": "+req.Reason)
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "User suspended"})
+    }
+}
+
+func UnsuspendUser(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        admin, target, ok := adminActionTarget(db, w, r)
+        if !ok {
+            return
+        }
+
+        err := models.UnsuspendUser(db, target.ID)
+        if err != nil {
+            fmt.Println("Error unsuspending user:", err)
+            http.Error(w, "Error unsuspending user", http.StatusInternalServerError)
+            return
+        }
+        recordAdminAudit(db, r, admin, target, models.AuditUserUnsuspended, "")
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "User unsuspended"})
+    }
+}
+
+// ForceLogout ends every session of the user; personal access tokens are not affected
+func ForceLogout(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        admin, target, ok := adminActionTarget(db, w, r)
+        if !ok {
+            return
+        }
+
+        err := models.RevokeUserRefreshTokens(db, target.ID)
+        if err != nil {
+            fmt.Println("Error revoking sessions:", err)
+            http.Error(w, "Error logging out user", http.StatusInternalServerError)
+            return
+        }
+        recordAdminAudit(db, r, admin, target, models.AuditUserLoggedOut, "")
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "User logged out of all sessions"})
+    }
+}
+
+// RemoveUser deletes an account right away, without the grace period users get.
+// ?mode=delete also deletes the user's posts; by default they are anonymized.
+func RemoveUser(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        mode := r.URL.Query().Get("mode")
+        if mode == "" {
+            mode = models.DeletionModeAnonymize
+        }
+        if mode != models.DeletionModeAnonymize && mode != models.DeletionModeDelete {
+            http.Error(w, `mode must be "anonymize" or "delete"`, http.StatusBadRequest)
+            return
+        }
+
+        admin, target, ok := adminActionTarget(db, w, r)
+        if !ok {
+            return
+        }
+
+        posts, err := models.DeleteAccount(db, target.ID, mode)
+        if err != nil {
+            fmt.Println("Error removing user:", err)
+            http.Error(w, "Error removing user", http.StatusInternalServerError)
+            return
+        }
+        if mode == models.DeletionModeDelete {
+            for _, postID := range posts {
+                err = searcher.RemovePost(postID)
+                if err != nil {
+                    fmt.Println("Error removing post from search index:", err)
+                }
+            }
+        }
+        recordAdminAudit(db, r, admin, target, models.AuditUserRemoved,
+            fmt.Sprintf("removed account %q, %d posts %s", target.Username, len(posts), mode+"d"))
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "User removed"})
+    }
+}
+
+// adminActionTarget loads the admin and the user they act on, refusing actions on
+// the admin's own account so nobody can lock themselves out
+func adminActionTarget(db *sql.DB, w http.ResponseWriter, r *http.Request) (*models.User, *models.User, bool) {
+    admin, ok := middleware.CurrentUser(r)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return nil, nil, false
+    }
+
+    target, ok := adminTargetUser(db, w, r)
+    if !ok {
+        return nil, nil, false
+    }
+    if target.ID == admin.ID {
+        http.Error(w, "You can't do this to your own account", http.StatusBadRequest)
+        return nil, nil, false
+    }
+    return admin, target, true
+}
+
+func recordAdminAudit(db *sql.DB, r *http.Request, admin, target *models.User, action, details string) {
+    err := models.RecordAudit(db, &models.AuditEntry{
+        ActorUserID:  &admin.ID,
+        Action:       action,
+        TargetUserID: &target.ID,
+        IP:           utils.ClientIP(r),
+        Details:      details,
+    })
+    if err != nil {
+        fmt.Println("Error writing audit log:", err)
+    }
+}
+
+// adminTargetUser loads the user named by the {id} route variable, writing a 404 if there is none
+func adminTargetUser(db *sql.DB, w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+    id, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return nil, false
+    }
+
+    user, err := models.GetUserByID(db, id)
+    if err == models.ErrUserNotFound {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return nil, false
+    }
+    if err != nil {
+        fmt.Println("Error fetching user:", err)
+        http.Error(w, "Internal server error", http.StatusInternalServerError)
+        return nil, false
+    }
+    return user, true
+}
+
+func containsString(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
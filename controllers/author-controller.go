@@ -0,0 +1,51 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+    "blog-app/models"
+    "github.com/gorilla/mux"
+)
+
+// GetAuthorProfile is the public page of an author: no email or other private fields
+func GetAuthorProfile(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, err := models.GetUserByUsername(db, mux.Vars(r)["username"])
+        if err == models.ErrUserNotFound {
+            http.Error(w, "User not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error fetching user:", err)
+            http.Error(w, "Error fetching user", http.StatusInternalServerError)
+            return
+        }
+
+        posts, err := models.GetPublishedPostsByAuthor(db, user.ID)
+        if err != nil {
+            fmt.Println("Error fetching posts:", err)
+            http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+            return
+        }
+
+        response := struct {
+            Username string        `json:"username"`
+            Name     string        `json:"name"`
+            Bio      string        `json:"bio"`
+            JoinedAt time.Time     `json:"joined_at"`
+            Posts    []models.Post `json:"posts"`
+        }{
+            Username: user.Username,
+            Name:     user.Name,
+            Bio:      user.Bio,
+            JoinedAt: user.CreatedAt,
+            Posts:    posts,
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(response)
+    }
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "blog-app/utils"
+)
+
+// JWKS publishes the public signing keys so other services can verify our tokens
+func JWKS() http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        keys, err := utils.LoadSigningKeys()
+        if err != nil {
+            fmt.Println("Error loading signing keys:", err)
+            http.Error(w, "Error loading signing keys", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "public, max-age=300")
+        json.NewEncoder(w).Encode(map[string][]utils.JWK{"keys": keys.JWKS()})
+    }
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+    "database/sql"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+// Failed logins are counted over a sliding window. After a few failures each further
+// attempt on the account has to wait twice as long, and past the threshold the account
+// (or, for many failures from one address, the IP) is locked out for a while.
+func loginFailureWindow() time.Duration {
+    return utils.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+}
+
+func loginDelayAfter() int {
+    return utils.GetEnvInt("LOGIN_DELAY_AFTER", 3)
+}
+
+func loginDelayBase() time.Duration {
+    return utils.GetEnvDuration("LOGIN_DELAY_BASE", time.Second)
+}
+
+func loginDelayMax() time.Duration {
+    return utils.GetEnvDuration("LOGIN_DELAY_MAX", 30*time.Second)
+}
+
+func loginLockoutThreshold(scope string) int {
+    if scope == models.LockoutScopeIP {
+        return utils.GetEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 50)
+    }
+    return utils.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10)
+}
+
+func loginLockoutDuration() time.Duration {
+    return utils.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+func normalizeLoginEmail(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBlocked writes a 429 and returns true when the email or IP is locked out,
+// or when the account is still inside its progressive delay
+func loginBlocked(db *sql.DB, w http.ResponseWriter, email, ip string) bool {
+    now := time.Now()
+
+    for _, check := range []struct{ scope, key string }{{models.LockoutScopeIP, ip}, {models.LockoutScopeEmail, email}} {
+        lockout, err := models.GetActiveLockout(db, check.scope, check.key, now)
+        if err != nil {
+            fmt.Println("Error checking lockout:", err)
+            http.Error(w, "Error logging in", http.StatusInternalServerError)
+            return true
+        }
+        if lockout != nil {
+            tooManyAttempts(w, lockout.LockedUntil.Sub(now))
+            return true
+        }
+    }
+
+    failures, last, err := recentLoginFailures(db, models.LockoutScopeEmail, email, now)
+    if err != nil {
+        fmt.Println("Error counting failed logins:", err)
+        http.Error(w, "Error logging in", http.StatusInternalServerError)
+        return true
+    }
+    if last != nil {
+        if wait := loginDelay(failures); wait > 0 && now.Before(last.Add(wait)) {
+            tooManyAttempts(w, last.Add(wait).Sub(now))
+            return true
+        }
+    }
+    return false
+}
+
+// loginDelay is how long to wait after the latest failure before the next attempt
+func loginDelay(failures int) time.Duration {
+    excess := failures - loginDelayAfter()
+    if excess < 0 {
+        return 0
+    }
+    delay := float64(loginDelayBase()) * math.Pow(2, float64(excess))
+    if max := loginDelayMax(); delay > float64(max) {
+        return max
+    }
+    return time.Duration(delay)
+}
+
+// recentLoginFailures counts failures in the window that came after the latest lockout
+// and, for accounts, after the latest successful login
+func recentLoginFailures(db *sql.DB, scope, key string, now time.Time) (int, *time.Time, error) {
+    since := now.Add(-loginFailureWindow())
+
+    lockedAt, err := models.LatestLockoutStart(db, scope, key)
+    if err != nil {
+        return 0, nil, err
+    }
+    if lockedAt != nil && lockedAt.After(since) {
+        since = *lockedAt
+    }
+
+    if scope == models.LockoutScopeEmail {
+        succeededAt, err := models.LastSuccessfulLogin(db, key)
+        if err != nil {
+            return 0, nil, err
+        }
+        if succeededAt != nil && succeededAt.After(since) {
+            since = *succeededAt
+        }
+    }
+
+    return models.FailedLoginsSince(db, scope, key, since)
+}
+
+// recordLoginFailure stores a failed attempt and locks out the account or IP once
+// it crosses its threshold. user is nil when the email doesn't belong to an account.
+func recordLoginFailure(db *sql.DB, email, ip string, user *models.User) {
+    now := time.Now()
+    err := models.RecordLoginAttempt(db, email, ip, false, now)
+    if err != nil {
+        fmt.Println("Error recording login attempt:", err)
+        return
+    }
+
+    for _, check := range []struct{ scope, key string }{{models.LockoutScopeEmail, email}, {models.LockoutScopeIP, ip}} {
+        failures, _, err := recentLoginFailures(db, check.scope, check.key, now)
+        if err != nil {
+            fmt.Println("Error counting failed logins:", err)
+            continue
+        }
+        if failures < loginLockoutThreshold(check.scope) {
+            continue
+        }
+
+        lockout := &models.Lockout{Scope: check.scope, Key: check.key, LockedUntil: now.Add(loginLockoutDuration()), CreatedAt: now}
+        err = models.CreateLockout(db, lockout)
+        if err != nil {
+            fmt.Println("Error creating lockout:", err)
+            continue
+        }
+
+        entry := &models.AuditEntry{
+            Action:    models.AuditIPLocked,
+            IP:        ip,
+            Details:   fmt.Sprintf("%d failed logins for %s, locked until %s", failures, check.key, lockout.LockedUntil.Format(time.RFC3339)),
+            CreatedAt: now,
+        }
+        if check.scope == models.LockoutScopeEmail {
+            entry.Action = models.AuditAccountLocked
+            if user != nil {
+                entry.TargetUserID = &user.ID
+            }
+        }
+        err = models.RecordAudit(db, entry)
+        if err != nil {
+            fmt.Println("Error writing audit log:", err)
+        }
+    }
+}
+
+// recordLoginSuccess resets the account's failure count
+func recordLoginSuccess(db *sql.DB, email, ip string) {
+    err := models.RecordLoginAttempt(db, email, ip, true, time.Now())
+    if err != nil {
+        fmt.Println("Error recording login attempt:", err)
+    }
+}
+
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+    seconds := int(math.Ceil(wait.Seconds()))
+    if seconds < 1 {
+        seconds = 1
+    }
+    w.Header().Set("Retry-After", strconv.Itoa(seconds))
+    http.Error(w, "Too many failed login attempts, please try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "io"
+    "net/http"
+    "net/url"
+    "time"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+func magicLinkTTL() time.Duration {
+    return utils.GetEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+}
+
+func RequestMagicLink(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Email string `json:"email"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.Email == "" {
+            http.Error(w, "Email is required", http.StatusBadRequest)
+            return
+        }
+
+        // Same response either way, so registered emails can't be discovered. Failures
+        // after the lookup are only logged, since an error would give the account away.
+        response := map[string]string{"message": "If that email is registered, a sign-in link has been sent"}
+
+        user, err := models.GetUserByEmail(db, req.Email)
+        if err != nil {
+            fmt.Println("Magic link requested for unknown email:", err)
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(response)
+            return
+        }
+
+        ttl := magicLinkTTL()
+        token, err := utils.GenerateMagicLinkToken(db, user, ttl)
+        if err != nil {
+            fmt.Println("Error creating magic link:", err)
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(response)
+            return
+        }
+
+        link := utils.AppURL("/login/magic/verify?token=" + url.QueryEscape(token))
+        body := fmt.Sprintf("Hi %s,\n\nUse this link to sign in. It works once and expires in %d minutes:\n\n%s\n\n"+
+            "If you didn't ask to sign in, you can ignore this email.\n", user.Name, int(ttl.Minutes()), link)
+
+        err = mailer.Send(user.Email, "Your sign-in link", body)
+        if err != nil {
+            fmt.Println("Error sending magic link:", err)
+        }
+
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(response)
+    }
+}
+
+// magicLinkConfirmPage asks the user to confirm the sign-in. Mail scanners and link
+// prefetchers follow links but don't submit forms, so they can't use up the token.
+var magicLinkConfirmPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="post" action="?token={{.}}">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// ConfirmMagicLink is where the emailed link points. Opening it doesn't sign in; the
+// page posts the token to VerifyMagicLink when the user confirms.
+func ConfirmMagicLink() http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        token := r.URL.Query().Get("token")
+        if token == "" {
+            http.Error(w, "Token is required", http.StatusBadRequest)
+            return
+        }
+
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Header().Set("Cache-Control", "no-store")
+        w.Header().Set("Referrer-Policy", "no-referrer")
+        w.Header().Set("X-Frame-Options", "DENY")
+        err := magicLinkConfirmPage.Execute(w, token)
+        if err != nil {
+            fmt.Println("Error rendering magic link page:", err)
+        }
+    }
+}
+
+// VerifyMagicLink exchanges a sign-in link for tokens. The token can come from the
+// link's query string or, for frontends that intercept the link, a JSON body.
+func VerifyMagicLink(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        token := r.URL.Query().Get("token")
+        if token == "" {
+            var req struct {
+                Token string `json:"token"`
+            }
+            err := json.NewDecoder(r.Body).Decode(&req)
+            if err != nil && err != io.EOF {
+                http.Error(w, "Invalid request payload", http.StatusBadRequest)
+                return
+            }
+            token = req.Token
+        }
+        if token == "" {
+            http.Error(w, "Token is required", http.StatusBadRequest)
+            return
+        }
+
+        userID, err := utils.ConsumeMagicLinkToken(db, token)
+        if err == utils.ErrInvalidMagicLink {
+            http.Error(w, "Invalid or expired sign-in link", http.StatusUnauthorized)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error consuming magic link:", err)
+            http.Error(w, "Error signing in", http.StatusInternalServerError)
+            return
+        }
+
+        user, err := models.GetUserByID(db, userID)
+        if err != nil {
+            http.Error(w, "User not found", http.StatusUnauthorized)
+            return
+        }
+
+        // Following the link proves the user controls the address
+        if user.EmailVerifiedAt == nil {
+            err = models.MarkEmailVerified(db, user.ID)
+            if err != nil {
+                fmt.Println("Error marking email verified:", err)
+            }
+        }
+
+        completeLogin(db, w, r, user)
+    }
+}
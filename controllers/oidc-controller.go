@@ -0,0 +1,288 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+const (
+    oidcStateCookie = "oidc_state"
+    oidcStateTTL    = 10 * time.Minute
+)
+
+// errOIDCEmailTaken means the provider's email belongs to an account that can't be
+// linked automatically
+var errOIDCEmailTaken = errors.New("an account with this email already exists; log in with your password and link your identity provider from there")
+
+// StartOIDCLogin redirects the browser to the identity provider
+func StartOIDCLogin(provider *utils.OIDCProvider) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        authURL, ok := startOIDC(w, r, provider, 0)
+        if !ok {
+            return
+        }
+        http.Redirect(w, r, authURL, http.StatusFound)
+    }
+}
+
+// StartOIDCLink begins linking the identity provider to the logged-in user. It returns
+// the authorization URL for the client to open; the callback then links the
+// provider's subject instead of logging in.
+func StartOIDCLink(provider *utils.OIDCProvider) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        authURL, ok := startOIDC(w, r, provider, user.ID)
+        if !ok {
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+    }
+}
+
+// startOIDC creates the login state, keeps it in a cookie and returns the provider's
+// authorization URL. linkUserID is 0 for a login.
+func startOIDC(w http.ResponseWriter, r *http.Request, provider *utils.OIDCProvider, linkUserID int) (string, bool) {
+    state, err := utils.NewOIDCLoginState(oidcStateTTL)
+    if err != nil {
+        fmt.Println("Error creating OIDC state:", err)
+        http.Error(w, "Error starting login", http.StatusInternalServerError)
+        return "", false
+    }
+    state.LinkUserID = linkUserID
+
+    authURL, err := provider.AuthCodeURL(r.Context(), state)
+    if err != nil {
+        fmt.Println("Error building OIDC authorization URL:", err)
+        http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+        return "", false
+    }
+
+    // State, nonce and PKCE verifier travel in a signed cookie until the callback
+    cookieValue, err := utils.SignOIDCLoginState(state)
+    if err != nil {
+        fmt.Println("Error signing OIDC state:", err)
+        http.Error(w, "Error starting login", http.StatusInternalServerError)
+        return "", false
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name:     oidcStateCookie,
+        Value:    cookieValue,
+        Path:     "/login/oidc",
+        MaxAge:   int(oidcStateTTL.Seconds()),
+        HttpOnly: true,
+        Secure:   strings.HasPrefix(provider.RedirectURL, "https://"),
+        SameSite: http.SameSiteLaxMode,
+    })
+    return authURL, true
+}
+
+// FinishOIDCLogin handles the provider's redirect back, provisioning the user on first login
+func FinishOIDCLogin(db *sql.DB, provider *utils.OIDCProvider) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        if providerError := query.Get("error"); providerError != "" {
+            http.Error(w, "Login failed at identity provider: "+providerError, http.StatusUnauthorized)
+            return
+        }
+
+        cookie, err := r.Cookie(oidcStateCookie)
+        if err != nil {
+            http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+            return
+        }
+        http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1})
+
+        state, err := utils.ParseOIDCLoginState(cookie.Value)
+        if err != nil || query.Get("state") != state.State {
+            http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+            return
+        }
+
+        code := query.Get("code")
+        if code == "" {
+            http.Error(w, "Authorization code missing", http.StatusBadRequest)
+            return
+        }
+
+        claims, err := provider.Exchange(r.Context(), code, state)
+        if err != nil {
+            fmt.Println("Error completing OIDC login:", err)
+            http.Error(w, "Login failed at identity provider", http.StatusUnauthorized)
+            return
+        }
+
+        if state.LinkUserID != 0 {
+            linkOIDCIdentity(db, w, provider.Issuer, claims, state.LinkUserID)
+            return
+        }
+
+        user, err := provisionOIDCUser(db, provider.Issuer, claims)
+        if err == errOIDCEmailTaken {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error provisioning OIDC user:", err)
+            http.Error(w, "Error completing login", http.StatusInternalServerError)
+            return
+        }
+
+        if middleware.RejectSuspended(w, user) {
+            return
+        }
+
+        // The identity provider is responsible for second factors
+        tokens, err := utils.IssueTokens(db, user, r)
+        if err != nil {
+            fmt.Println("Error generating JWT:", err)
+            http.Error(w, "Error generating token", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
+
+// linkOIDCIdentity finishes a link started by StartOIDCLink, attaching the provider's
+// subject to the user who started it
+func linkOIDCIdentity(db *sql.DB, w http.ResponseWriter, issuer string, claims *utils.OIDCClaims, userID int) {
+    user, err := models.GetUserByID(db, userID)
+    if err == models.ErrUserNotFound {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        fmt.Println("Error fetching user:", err)
+        http.Error(w, "Error linking identity provider", http.StatusInternalServerError)
+        return
+    }
+
+    linked, err := models.GetUserByOIDCSubject(db, issuer, claims.Subject)
+    if err == nil && linked.ID != user.ID {
+        http.Error(w, "This identity is already linked to another account", http.StatusConflict)
+        return
+    }
+    if err != nil && err != models.ErrUserNotFound {
+        fmt.Println("Error fetching OIDC user:", err)
+        http.Error(w, "Error linking identity provider", http.StatusInternalServerError)
+        return
+    }
+
+    err = models.LinkOIDCIdentity(db, user.ID, issuer, claims.Subject)
+    if err != nil {
+        fmt.Println("Error linking OIDC identity:", err)
+        http.Error(w, "Error linking identity provider", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"message": "Identity provider linked"})
+}
+
+// provisionOIDCUser finds the user for the subject claim, linking an existing account
+// with the same verified email or creating a new one on first login
+func provisionOIDCUser(db *sql.DB, issuer string, claims *utils.OIDCClaims) (*models.User, error) {
+    user, err := models.GetUserByOIDCSubject(db, issuer, claims.Subject)
+    if err == nil {
+        return user, nil
+    }
+    if err != models.ErrUserNotFound {
+        return nil, err
+    }
+
+    if claims.Email == "" {
+        return nil, errors.New("identity provider did not return an email address")
+    }
+
+    // Reserved addresses belong to accounts nobody may log in as
+    if models.IsReservedEmail(claims.Email) {
+        return nil, errOIDCEmailTaken
+    }
+
+    existing, err := models.GetUserByEmail(db, claims.Email)
+    if err != nil && err != models.ErrUserNotFound {
+        return nil, err
+    }
+    if err == nil {
+        // Only trust the provider's word for who owns an email if it verified it. Logging
+        // in through the provider skips our second factor, so an account that has one is
+        // only linked after its owner logs in normally.
+        if !claims.EmailVerified || existing.TOTPEnabledAt != nil {
+            return nil, errOIDCEmailTaken
+        }
+        err = models.LinkOIDCIdentity(db, existing.ID, issuer, claims.Subject)
+        if err != nil {
+            return nil, err
+        }
+        return existing, nil
+    }
+
+    username, err := availableUsername(db, claims)
+    if err != nil {
+        return nil, err
+    }
+
+    user = &models.User{
+        Username: username,
+        Name:     claims.Name,
+        Email:    claims.Email,
+    }
+    if claims.EmailVerified {
+        now := time.Now()
+        user.EmailVerifiedAt = &now
+    }
+    err = models.CreateOIDCUser(db, user, issuer, claims.Subject)
+    if err != nil {
+        return nil, err
+    }
+    return user, nil
+}
+
+// availableUsername derives a username from the claims, adding a suffix if it is taken
+func availableUsername(db *sql.DB, claims *utils.OIDCClaims) (string, error) {
+    base := claims.PreferredUsername
+    if base == "" {
+        base, _, _ = strings.Cut(claims.Email, "@")
+    }
+    base = usernameUnsafeChars.ReplaceAllString(base, "")
+    if base == "" {
+        base = "user"
+    }
+    if len(base) > 80 {
+        base = base[:80]
+    }
+
+    candidate := base
+    for i := 0; i < 5; i++ {
+        taken, err := models.UsernameExists(db, candidate)
+        if err != nil {
+            return "", err
+        }
+        if !taken {
+            return candidate, nil
+        }
+        suffix, err := utils.GenerateRandomToken(3)
+        if err != nil {
+            return "", err
+        }
+        candidate = base + "-" + strings.ToLower(usernameUnsafeChars.ReplaceAllString(suffix, ""))
+    }
+    return "", errors.New("could not find a free username")
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+const passwordResetTTL = time.Hour
+
+func ForgotPassword(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Email string `json:"email"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.Email == "" {
+            http.Error(w, "Email is required", http.StatusBadRequest)
+            return
+        }
+
+        // Always answer the same way so the endpoint can't be used to find registered emails.
+        // Failures after the lookup are only logged, since an error would give the account away.
+        response := map[string]string{"message": "If that email is registered, a reset link has been sent"}
+
+        user, err := models.GetUserByEmail(db, req.Email)
+        if err != nil {
+            fmt.Println("Password reset requested for unknown email:", err)
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(response)
+            return
+        }
+
+        // Only the most recent link should work
+        err = models.InvalidateUserTokens(db, user.ID, models.TokenPurposePasswordReset)
+        if err != nil {
+            fmt.Println("Error invalidating old reset tokens:", err)
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(response)
+            return
+        }
+
+        token, err := utils.CreateUserToken(db, user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+        if err != nil {
+            fmt.Println("Error creating reset token:", err)
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(response)
+            return
+        }
+
+        link := utils.AppURL("/password/reset?token=" + url.QueryEscape(token))
+        body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+            "Use this link within the next hour to choose a new one:\n\n%s\n\n"+
+            "If it wasn't you, you can ignore this email.\n", user.Name, link)
+
+        err = mailer.Send(user.Email, "Reset your password", body)
+        if err != nil {
+            fmt.Println("Error sending reset email:", err)
+        }
+
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(response)
+    }
+}
+
+func ResetPassword(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Token       string `json:"token"`
+            NewPassword string `json:"new_password"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.Token == "" {
+            http.Error(w, "Token and new_password are required", http.StatusBadRequest)
+            return
+        }
+
+        // Check the new password before using up the token, so a rejected password can be retried
+        tokenHash := utils.HashToken(req.Token)
+        pending, err := models.GetValidUserToken(db, models.TokenPurposePasswordReset, tokenHash)
+        if err == models.ErrUserTokenInvalid {
+            http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error fetching reset token:", err)
+            http.Error(w, "Error resetting password", http.StatusInternalServerError)
+            return
+        }
+
+        user, err := models.GetUserByID(db, pending.UserID)
+        if err != nil {
+            fmt.Println("Error fetching user:", err)
+            http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+            return
+        }
+
+        if rejectWeakPassword(w, req.NewPassword, user.Username, user.Email) {
+            return
+        }
+
+        token, err := models.ConsumeUserToken(db, models.TokenPurposePasswordReset, tokenHash)
+        if err == models.ErrUserTokenInvalid {
+            http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error consuming reset token:", err)
+            http.Error(w, "Error resetting password", http.StatusInternalServerError)
+            return
+        }
+
+        hashedPassword, err := utils.HashPassword(req.NewPassword)
+        if err != nil {
+            fmt.Println("Error hashing password:", err)
+            http.Error(w, "Error resetting password", http.StatusInternalServerError)
+            return
+        }
+
+        err = models.UpdatePassword(db, token.UserID, hashedPassword)
+        if err != nil {
+            fmt.Println("Error updating password:", err)
+            http.Error(w, "Error resetting password", http.StatusInternalServerError)
+            return
+        }
+
+        // Whoever knew the old password shouldn't stay logged in
+        err = models.RevokeUserRefreshTokens(db, token.UserID)
+        if err != nil {
+            fmt.Println("Error revoking refresh tokens:", err)
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+    }
+}
+
+// ChangePassword sets a new password for the logged-in user. Every access and refresh
+// token issued before the change stops working, and the response carries a fresh pair.
+func ChangePassword(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            CurrentPassword string `json:"current_password"`
+            NewPassword     string `json:"new_password"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+            http.Error(w, "current_password and new_password are required", http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        claims, claimsOK := middleware.CurrentClaims(r)
+        if !ok || !claimsOK {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        // A stolen access token shouldn't allow guessing the password faster than /login does
+        email := normalizeLoginEmail(user.Email)
+        ip := utils.ClientIP(r)
+        if loginBlocked(db, w, email, ip) {
+            return
+        }
+
+        valid, _, err := utils.VerifyPassword(user.Password, req.CurrentPassword)
+        if err != nil || !valid {
+            recordLoginFailure(db, email, ip, user)
+            http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+            return
+        }
+
+        if rejectWeakPassword(w, req.NewPassword, user.Username, user.Email) {
+            return
+        }
+
+        hashedPassword, err := utils.HashPassword(req.NewPassword)
+        if err != nil {
+            fmt.Println("Error hashing password:", err)
+            http.Error(w, "Error changing password", http.StatusInternalServerError)
+            return
+        }
+
+        // Access tokens issued before now are rejected from here on
+        err = models.UpdatePassword(db, user.ID, hashedPassword)
+        if err != nil {
+            fmt.Println("Error updating password:", err)
+            http.Error(w, "Error changing password", http.StatusInternalServerError)
+            return
+        }
+
+        // The token used for this request may share the second of the change, so revoke it explicitly
+        err = models.RevokeAccessToken(db, claims.ID, claims.ExpiresAt.Time)
+        if err != nil {
+            fmt.Println("Error revoking access token:", err)
+        }
+        err = models.RevokeUserRefreshTokens(db, user.ID)
+        if err != nil {
+            fmt.Println("Error revoking refresh tokens:", err)
+            http.Error(w, "Error changing password", http.StatusInternalServerError)
+            return
+        }
+
+        tokens, err := utils.IssueTokens(db, user, r)
+        if err != nil {
+            fmt.Println("Error generating JWT:", err)
+            http.Error(w, "Error generating token", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
+
+// rejectWeakPassword writes a 400 listing every policy violation and returns true
+// when the password is not acceptable
+func rejectWeakPassword(w http.ResponseWriter, password, username, email string) bool {
+    violations, err := utils.CheckPassword(password, username, email)
+    if err != nil {
+        fmt.Println("Error loading password policy:", err)
+        http.Error(w, "Error checking password", http.StatusInternalServerError)
+        return true
+    }
+    if len(violations) == 0 {
+        return false
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusBadRequest)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "error":   "Password does not meet the password policy",
+        "reasons": violations,
+    })
+    return true
+}
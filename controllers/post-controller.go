@@ -5,19 +5,24 @@ import (
     "encoding/json"
     "net/http"
     "blog-app/models"
-    "golang.org/x/crypto/bcrypt"
+    "blog-app/middleware"
+    "blog-app/utils"
     "fmt"
+    "net/url"
+    "strconv"
     "time"
     "github.com/gorilla/mux"
 )
 
-func ProfileHandler(db *sql.DB) http.HandlerFunc {
+const maxBioLength = 500
+
+func ProfileHandler(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         switch r.Method {
         case http.MethodGet:
             getProfile(db, w, r)
         case http.MethodPost:
-            updateProfile(db, w, r)
+            updateProfile(db, mailer, w, r)
         default:
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
         }
@@ -25,57 +30,48 @@ func ProfileHandler(db *sql.DB) http.HandlerFunc {
 }
 
 func getProfile(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        Email    string `json:"email"`
-        Password string `json:"password"`
-    }
-
-    // Decode the request body
-    err := json.NewDecoder(r.Body).Decode(&req)
-    if err != nil {
-        fmt.Println("Error decoding JSON:", err)
-        http.Error(w, "Invalid request payload", http.StatusBadRequest)
+    user, ok := middleware.CurrentUser(r)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
         return
     }
 
-    // Fetch the user by email
-    user, err := models.GetUserByEmail(db, req.Email)
+    roles, err := models.GetUserRoles(db, user.ID)
     if err != nil {
-        fmt.Println("Error fetching user by email:", err)
-        http.Error(w, "User not found", http.StatusNotFound)
+        fmt.Println("Error fetching roles:", err)
+        http.Error(w, "Internal server error", http.StatusInternalServerError)
         return
     }
 
-    // Check the password
-    err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-    if err != nil {
-        fmt.Println("Password comparison failed:", err)
-        http.Error(w, "Invalid password", http.StatusUnauthorized)
-        return
-    }
-
-    // Return the user profile (only name, username, and email)
+    // Return the user profile (only name, username, email, bio, verification time and roles)
     response := struct {
-        Name     string `json:"name"`
-        Username string `json:"username"`
-        Email    string `json:"email"`
+        Name            string     `json:"name"`
+        Username        string     `json:"username"`
+        Email           string     `json:"email"`
+        Bio             string     `json:"bio"`
+        EmailVerifiedAt *time.Time `json:"email_verified_at"`
+        Roles           []string   `json:"roles"`
     }{
-        Name:     user.Name,
-        Username: user.Username,
-        Email:    user.Email,
+        Name:            user.Name,
+        Username:        user.Username,
+        Email:           user.Email,
+        Bio:             user.Bio,
+        EmailVerifiedAt: user.EmailVerifiedAt,
+        Roles:           roles,
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(response)
 }
 
-func updateProfile(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func updateProfile(db *sql.DB, mailer utils.Mailer, w http.ResponseWriter, r *http.Request) {
     var req struct {
-        Email       string `json:"email"`
-        Password    string `json:"password"`
-        NewName     string `json:"new_name"`
-        NewUsername string `json:"new_username"`
-        NewEmail    string `json:"new_email"`
+        NewName     string  `json:"new_name"`
+        NewUsername string  `json:"new_username"`
+        NewEmail    string  `json:"new_email"`
+        NewBio      *string `json:"new_bio"`
+        // CurrentPassword confirms a change of email
+        CurrentPassword string `json:"current_password"`
     }
 
     // Decode the request body
@@ -86,57 +82,108 @@ func updateProfile(db *sql.DB, w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Fetch the user by email
-    user, err := models.GetUserByEmail(db, req.Email)
-    if err != nil {
-        fmt.Println("Error fetching user by email:", err)
-        http.Error(w, "User not found", http.StatusNotFound)
+    user, ok := middleware.CurrentUser(r)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
         return
     }
 
-    // Check the password
-    err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-    if err != nil {
-        fmt.Println("Password comparison failed:", err)
-        http.Error(w, "Invalid password", http.StatusUnauthorized)
-        return
+    // Only overwrite the fields that were provided
+    if req.NewName != "" {
+        user.Name = req.NewName
+    }
+    if req.NewUsername != "" {
+        if !validUsername(req.NewUsername) {
+            http.Error(w, "Username may only contain letters, digits, '.', '_' and '-' and be at most 100 characters", http.StatusBadRequest)
+            return
+        }
+        user.Username = req.NewUsername
+    }
+    // An empty bio clears it, so it is only skipped when absent
+    if req.NewBio != nil {
+        if len([]rune(*req.NewBio)) > maxBioLength {
+            http.Error(w, fmt.Sprintf("Bio must be at most %d characters", maxBioLength), http.StatusBadRequest)
+            return
+        }
+        user.Bio = *req.NewBio
+    }
+    // The email is where password resets go, so changing it takes a login session and
+    // the current password. A new address has to be verified again.
+    oldEmail := user.Email
+    emailChanged := req.NewEmail != "" && req.NewEmail != user.Email
+    if emailChanged {
+        if models.IsReservedEmail(req.NewEmail) {
+            http.Error(w, "This email address can't be used", http.StatusBadRequest)
+            return
+        }
+        if _, isToken := middleware.CurrentPersonalAccessToken(r); isToken {
+            http.Error(w, "Personal access tokens can't change the email address", http.StatusForbidden)
+            return
+        }
+        if !confirmEmailChange(db, w, r, user, req.CurrentPassword) {
+            return
+        }
+        user.Email = req.NewEmail
+        user.EmailVerifiedAt = nil
     }
 
-    // Update the user profile
-    updateQuery := `
-        UPDATE users
-        SET name = COALESCE(?, name),
-            username = COALESCE(?, username),
-            email = COALESCE(?, email)
-        WHERE email = ?`
-
-    _, err = db.Exec(updateQuery, req.NewName, req.NewUsername, req.NewEmail, req.Email)
+    err = user.UpdateProfile(db)
+    if models.IsDuplicateKey(err) {
+        http.Error(w, "Username or email is already taken", http.StatusConflict)
+        return
+    }
     if err != nil {
         fmt.Println("Error updating user profile:", err)
         http.Error(w, "Error updating user profile", http.StatusInternalServerError)
         return
     }
 
+    if emailChanged {
+        err = sendVerificationEmail(db, mailer, user)
+        if err != nil {
+            fmt.Println("Error sending verification email:", err)
+        }
+
+        // Tell the old address, in case someone else is using their login
+        body := fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. "+
+            "If you didn't do this, reset your password and contact us right away.\n", user.Name, user.Email)
+        err = mailer.Send(oldEmail, "Your email address was changed", body)
+        if err != nil {
+            fmt.Println("Error sending email change notice:", err)
+        }
+    }
+
     fmt.Println("Profile updated successfully")
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"message": "Profile updated successfully"})
 }
 
-func authenticateUser(db *sql.DB, email, password string) (int, error) {
-    user, err := models.GetUserByEmail(db, email)
-    if err != nil {
-        return 0, err
+// confirmEmailChange checks the current password sent with a change of email, with the
+// same lockout as /login. Accounts created through single sign-on have no password.
+func confirmEmailChange(db *sql.DB, w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+    email := normalizeLoginEmail(user.Email)
+    ip := utils.ClientIP(r)
+    if loginBlocked(db, w, email, ip) {
+        return false
     }
 
-    err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-    // if err != nil {
-    //     return 0, errors.New("invalid email or password")
-    // }
-
-    return user.ID, nil
+    valid, _, err := utils.VerifyPassword(user.Password, password)
+    if err == utils.ErrUnknownPasswordHash {
+        return true
+    }
+    if password == "" {
+        http.Error(w, "current_password is required to change the email address", http.StatusBadRequest)
+        return false
+    }
+    if err != nil || !valid {
+        recordLoginFailure(db, email, ip, user)
+        http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+        return false
+    }
+    return true
 }
 
-func CreatePost(db *sql.DB) http.HandlerFunc {
+func CreatePost(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         var requestData map[string]interface{}
         err := json.NewDecoder(r.Body).Decode(&requestData)
@@ -145,39 +192,44 @@ func CreatePost(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        email, _ := requestData["email"].(string)
-        password, _ := requestData["password"].(string)
         name, _ := requestData["name"].(string)
         title, _ := requestData["title"].(string)
         content, _ := requestData["content"].(string)
 
-        if email == "" || password == "" || title == "" || content == "" {
-            http.Error(w, "Email, password, title, and content are required", http.StatusBadRequest)
+        if title == "" || content == "" {
+            http.Error(w, "Title and content are required", http.StatusBadRequest)
             return
         }
 
-        // Authenticate the user
-        userID, err := authenticateUser(db, email, password)
-        if err != nil {
-            http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+        // The authenticated user is set by the auth middleware
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
-        // Fetch user details based on userID
-        user, err := models.GetUserByID(db, userID)
-        if err != nil {
-            http.Error(w, "User not found", http.StatusUnauthorized)
+        if requireVerifiedEmail() && user.EmailVerifiedAt == nil {
+            http.Error(w, "Please verify your email address before posting", http.StatusForbidden)
             return
         }
 
-        // Create the post
+        // Create the post, published right away unless the request says otherwise
+        now := time.Now()
         post := &models.Post{
             Name:      name,
             Title:     title,
             Content:   content,
+            AuthorID:  &user.ID,
             Username:  user.Username,
-            CreatedAt: time.Now(),
-            UpdatedAt: time.Now(),
+            Status:    models.PostStatusPublished,
+            PublishAt: &now,
+            CreatedAt: now,
+            UpdatedAt: now,
+        }
+        err = applyPostStatus(post, requestData, now)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
         }
 
         err = models.CreatePost(db, post)
@@ -185,25 +237,193 @@ func CreatePost(db *sql.DB) http.HandlerFunc {
             http.Error(w, "Error creating post", http.StatusInternalServerError)
             return
         }
+        reindexPost(searcher, post)
 
         w.WriteHeader(http.StatusCreated)
         json.NewEncoder(w).Encode(post)
     }
 }
 
+// GetAllPosts lists published posts one page at a time, newest first unless ?sort= and
+// ?order= say otherwise. ?author= limits the list to one author; see servePostList for
+// paging and parsePostFilter for the other filters.
 func GetAllPosts(db *sql.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        posts, err := models.GetAllPosts(db)
+        query := r.URL.Query()
+        filter, err := parsePostFilter(query)
         if err != nil {
-            http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
+        filter.Status = models.PostStatusPublished
+
+        if author := query.Get("author"); author != "" {
+            user, err := models.GetUserByUsername(db, author)
+            if err == models.ErrUserNotFound {
+                // Nobody by that name, so nothing to list
+                w.Header().Set("Content-Type", "application/json")
+                json.NewEncoder(w).Encode(map[string]interface{}{"posts": []models.Post{}, "next": nil})
+                return
+            }
+            if err != nil {
+                fmt.Println("Error fetching user:", err)
+                http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+                return
+            }
+            filter.AuthorID = &user.ID
+        }
 
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(posts)
+        servePostList(db, w, r, filter)
+    }
+}
+
+// ListMyPosts lists the current user's posts in every status, including drafts and
+// scheduled posts. ?status= limits it to one status; the other parameters are the
+// same as for GetAllPosts.
+func ListMyPosts(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        query := r.URL.Query()
+        filter, err := parsePostFilter(query)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        filter.AuthorID = &user.ID
+        filter.Status = query.Get("status")
+        if filter.Status != "" && !models.IsValidPostStatus(filter.Status) {
+            http.Error(w, "status must be draft, scheduled, published or archived", http.StatusBadRequest)
+            return
+        }
+
+        servePostList(db, w, r, filter)
     }
 }
 
+// servePostList writes one page of posts matching filter. ?limit= sets the page size
+// and ?after= takes the cursor from the previous page's "next" link.
+func servePostList(db *sql.DB, w http.ResponseWriter, r *http.Request, filter models.PostFilter) {
+    query := r.URL.Query()
+
+    limit := models.DefaultPostPageSize
+    if value := query.Get("limit"); value != "" {
+        var err error
+        limit, err = strconv.Atoi(value)
+        if err != nil || limit < 1 {
+            http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+            return
+        }
+        if limit > models.MaxPostPageSize {
+            limit = models.MaxPostPageSize
+        }
+    }
+
+    var after *models.PostCursor
+    if value := query.Get("after"); value != "" {
+        var err error
+        after, err = models.DecodePostCursor(value)
+        if err != nil {
+            http.Error(w, "Invalid cursor", http.StatusBadRequest)
+            return
+        }
+    }
+
+    posts, next, err := models.ListPosts(db, filter, after, limit)
+    if err == models.ErrInvalidCursor {
+        http.Error(w, "Invalid cursor for this sort order", http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        fmt.Println("Error fetching posts:", err)
+        http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+        return
+    }
+
+    response := map[string]interface{}{
+        "posts": posts,
+        "next":  nil,
+    }
+    if next != nil {
+        // Keep the filters so the next page continues the same listing
+        nextQuery := url.Values{}
+        for key, values := range query {
+            nextQuery[key] = values
+        }
+        nextQuery.Set("limit", strconv.Itoa(limit))
+        nextQuery.Set("after", next.Encode())
+        response["next"] = r.URL.Path + "?" + nextQuery.Encode()
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+
+// parsePostFilter reads the post listing filters from the query string:
+// created_after/created_before and updated_after/updated_before (RFC 3339 or YYYY-MM-DD;
+// "after" is inclusive and "before" exclusive), title_prefix, sort (created_at,
+// updated_at or title) and order (asc or desc). The author is resolved by the caller.
+func parsePostFilter(query url.Values) (models.PostFilter, error) {
+    var filter models.PostFilter
+
+    var err error
+    filter.CreatedAfter, err = parseQueryTime(query, "created_after")
+    if err != nil {
+        return filter, err
+    }
+    filter.CreatedBefore, err = parseQueryTime(query, "created_before")
+    if err != nil {
+        return filter, err
+    }
+    filter.UpdatedAfter, err = parseQueryTime(query, "updated_after")
+    if err != nil {
+        return filter, err
+    }
+    filter.UpdatedBefore, err = parseQueryTime(query, "updated_before")
+    if err != nil {
+        return filter, err
+    }
+
+    filter.TitlePrefix = query.Get("title_prefix")
+
+    filter.Sort = query.Get("sort")
+    if filter.Sort == "" {
+        filter.Sort = models.PostSortCreatedAt
+    }
+    if !models.IsPostSortField(filter.Sort) {
+        return filter, fmt.Errorf("sort must be one of created_at, updated_at or title")
+    }
+
+    switch query.Get("order") {
+    case "", "desc":
+    case "asc":
+        filter.Ascending = true
+    default:
+        return filter, fmt.Errorf("order must be asc or desc")
+    }
+    return filter, nil
+}
+
+// parseQueryTime parses an optional RFC 3339 timestamp or a YYYY-MM-DD date (midnight UTC)
+func parseQueryTime(query url.Values, name string) (*time.Time, error) {
+    value := query.Get(name)
+    if value == "" {
+        return nil, nil
+    }
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        t, err = time.Parse("2006-01-02", value)
+        if err != nil {
+            return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+        }
+    }
+    return &t, nil
+}
+
 func GetPostByID(db *sql.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         vars := mux.Vars(r)
@@ -219,6 +439,20 @@ func GetPostByID(db *sql.DB) http.HandlerFunc {
             return
         }
 
+        // Unpublished posts look like missing ones to everyone but their author and editors
+        if !post.IsPublished() {
+            allowed, err := canViewUnpublishedPost(db, r, post)
+            if err != nil {
+                fmt.Println("Error checking permissions:", err)
+                http.Error(w, "Internal server error", http.StatusInternalServerError)
+                return
+            }
+            if !allowed {
+                http.Error(w, "No post exists with this post_id, please try again!", http.StatusNotFound)
+                return
+            }
+        }
+
         w.Header().Set("Content-Type", "application/json")
         w.WriteHeader(http.StatusOK)
         json.NewEncoder(w).Encode(post)
@@ -226,7 +460,7 @@ func GetPostByID(db *sql.DB) http.HandlerFunc {
 }
 
 
-func UpdatePost(db *sql.DB) http.HandlerFunc {
+func UpdatePost(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         // Extract postID from URL parameters
         vars := mux.Vars(r)
@@ -244,23 +478,18 @@ func UpdatePost(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        // Extract other fields from request body
-        email, _ := requestData["email"].(string)
-        password, _ := requestData["password"].(string)
-        newTitle, _ := requestData["title"].(string)
-        newContent, _ := requestData["content"].(string)
-
-        // Authenticate user and get user ID
-        userID, err := authenticateUser(db, email, password)
-        if err != nil {
-            http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+        // Extract the new fields from request body; fields that are left out keep their value
+        newTitle, hasTitle := requestData["title"].(string)
+        newContent, hasContent := requestData["content"].(string)
+        if (hasTitle && newTitle == "") || (hasContent && newContent == "") {
+            http.Error(w, "Title and content can't be empty", http.StatusBadRequest)
             return
         }
 
-        // Fetch user data using the userID
-        user, err := models.GetUserByID(db, userID)
-        if err != nil {
-            http.Error(w, "Error fetching user data", http.StatusInternalServerError)
+        // The authenticated user is set by the auth middleware
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
@@ -271,23 +500,39 @@ func UpdatePost(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        // Check authorization by comparing usernames
-        if post.Username != user.Username {
+        // Authors may update their own posts, editors and admins any post
+        allowed, err := canModifyPost(db, r, post, user, models.PermissionPostsUpdateOwn, models.PermissionPostsUpdateAny)
+        if err != nil {
+            fmt.Println("Error checking permissions:", err)
+            http.Error(w, "Internal server error", http.StatusInternalServerError)
+            return
+        }
+        if !allowed {
             http.Error(w, "You are not authorized to update this post", http.StatusForbidden)
             return
         }
 
         // Update post details
-        post.Title = newTitle
-        post.Content = newContent
+        if hasTitle {
+            post.Title = newTitle
+        }
+        if hasContent {
+            post.Content = newContent
+        }
         post.UpdatedAt = time.Now()
+        err = applyPostStatus(post, requestData, post.UpdatedAt)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
 
         // Save changes
-        err = models.UpdatePost(db, post)
+        err = models.UpdatePost(db, post, user.ID)
         if err != nil {
             http.Error(w, "Error updating post", http.StatusInternalServerError)
             return
         }
+        reindexPost(searcher, post)
 
         // Respond with updated post
         w.WriteHeader(http.StatusOK)
@@ -297,7 +542,7 @@ func UpdatePost(db *sql.DB) http.HandlerFunc {
 
 
 
-func DeletePost(db *sql.DB) http.HandlerFunc {
+func DeletePost(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         // Extract postID from URL parameters
         vars := mux.Vars(r)
@@ -307,29 +552,10 @@ func DeletePost(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        // Decode JSON request body
-        var requestData map[string]interface{}
-        err := json.NewDecoder(r.Body).Decode(&requestData)
-        if err != nil {
-            http.Error(w, "Invalid request payload", http.StatusBadRequest)
-            return
-        }
-
-        // Extract user credentials from request body
-        email, _ := requestData["email"].(string)
-        password, _ := requestData["password"].(string)
-
-        // Authenticate user
-        userID, err := authenticateUser(db, email, password)
-        if err != nil {
-            http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-            return
-        }
-
-        // Get the authenticated user's information (assuming you have a function for that)
-        user, err := models.GetUserByID(db, userID)
-        if err != nil {
-            http.Error(w, "User not found", http.StatusUnauthorized)
+        // The authenticated user is set by the auth middleware
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
@@ -340,8 +566,14 @@ func DeletePost(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        // Check authorization using Username instead of UserID
-        if post.Username != user.Username {
+        // Authors may delete their own posts, editors and admins any post
+        allowed, err := canModifyPost(db, r, post, user, models.PermissionPostsDeleteOwn, models.PermissionPostsDeleteAny)
+        if err != nil {
+            fmt.Println("Error checking permissions:", err)
+            http.Error(w, "Internal server error", http.StatusInternalServerError)
+            return
+        }
+        if !allowed {
             http.Error(w, "You are not authorized to delete this post", http.StatusForbidden)
             return
         }
@@ -352,9 +584,77 @@ func DeletePost(db *sql.DB) http.HandlerFunc {
             http.Error(w, "Error deleting post", http.StatusInternalServerError)
             return
         }
+        err = searcher.RemovePost(post.ID)
+        if err != nil {
+            fmt.Println("Error removing post from search index:", err)
+        }
 
         // Respond with success message
         w.WriteHeader(http.StatusOK)
         json.NewEncoder(w).Encode(map[string]string{"message": "Post deleted successfully"})
     }
 }
+
+// applyPostStatus applies the optional "status" and "publish_at" (RFC 3339) fields of a
+// create or update request. A publish_at without a status schedules the post.
+func applyPostStatus(post *models.Post, requestData map[string]interface{}, now time.Time) error {
+    status, hasStatus := requestData["status"].(string)
+    publishAtValue, hasPublishAt := requestData["publish_at"].(string)
+    if !hasStatus && !hasPublishAt {
+        return nil
+    }
+    if !hasStatus {
+        status = models.PostStatusScheduled
+    }
+    if !models.IsValidPostStatus(status) {
+        return fmt.Errorf("status must be draft, scheduled, published or archived")
+    }
+    if hasPublishAt && status != models.PostStatusScheduled {
+        return fmt.Errorf("publish_at can only be set for scheduled posts")
+    }
+
+    switch status {
+    case models.PostStatusScheduled:
+        if !hasPublishAt {
+            return fmt.Errorf("publish_at is required for scheduled posts")
+        }
+        publishAt, err := time.Parse(time.RFC3339, publishAtValue)
+        if err != nil || !publishAt.After(now) {
+            return fmt.Errorf("publish_at must be a future RFC 3339 timestamp")
+        }
+        post.PublishAt = &publishAt
+    case models.PostStatusPublished:
+        // Republishing an archived post keeps its original publish time
+        if post.PublishAt == nil || post.Status == models.PostStatusScheduled {
+            post.PublishAt = &now
+        }
+    case models.PostStatusDraft:
+        post.PublishAt = nil
+    }
+    post.Status = status
+    return nil
+}
+
+// canViewUnpublishedPost reports whether the signed-in user, if any, may see a draft,
+// scheduled or archived post
+func canViewUnpublishedPost(db *sql.DB, r *http.Request, post *models.Post) (bool, error) {
+    user, ok := middleware.CurrentUser(r)
+    if !ok {
+        return false, nil
+    }
+    if post.IsAuthor(user.ID) {
+        return true, nil
+    }
+    return middleware.HasPermission(db, r, models.PermissionPostsUpdateAny)
+}
+
+// canModifyPost checks the "own" permission for the author's own posts and the "any" permission otherwise
+func canModifyPost(db *sql.DB, r *http.Request, post *models.Post, user *models.User, ownPermission, anyPermission string) (bool, error) {
+    if post.IsAuthor(user.ID) {
+        allowed, err := middleware.HasPermission(db, r, ownPermission)
+        if err != nil || allowed {
+            return allowed, err
+        }
+    }
+    return middleware.HasPermission(db, r, anyPermission)
+}
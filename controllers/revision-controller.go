@@ -0,0 +1,172 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+    "github.com/gorilla/mux"
+)
+
+// diffContextLines is how many unchanged lines are shown around each change in a diff
+const diffContextLines = 3
+
+// ListPostRevisions lists the saved versions of a post, newest first
+func ListPostRevisions(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        post, _, ok := revisionPost(db, w, r)
+        if !ok {
+            return
+        }
+
+        revisions, err := models.GetPostRevisions(db, post.ID)
+        if err != nil {
+            fmt.Println("Error fetching revisions:", err)
+            http.Error(w, "Error fetching revisions", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(revisions)
+    }
+}
+
+func GetPostRevision(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        post, _, ok := revisionPost(db, w, r)
+        if !ok {
+            return
+        }
+
+        number, _ := strconv.Atoi(mux.Vars(r)["revision"])
+        revision, ok := loadRevision(db, w, post.ID, number)
+        if !ok {
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(revision)
+    }
+}
+
+// DiffPostRevisions compares two revisions given as ?from= and ?to=, returning the
+// changes to the title and content as a unified diff
+func DiffPostRevisions(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        post, _, ok := revisionPost(db, w, r)
+        if !ok {
+            return
+        }
+
+        fromNumber, err := strconv.Atoi(r.URL.Query().Get("from"))
+        if err != nil {
+            http.Error(w, "from must be a revision number", http.StatusBadRequest)
+            return
+        }
+        toNumber, err := strconv.Atoi(r.URL.Query().Get("to"))
+        if err != nil {
+            http.Error(w, "to must be a revision number", http.StatusBadRequest)
+            return
+        }
+
+        from, ok := loadRevision(db, w, post.ID, fromNumber)
+        if !ok {
+            return
+        }
+        to, ok := loadRevision(db, w, post.ID, toNumber)
+        if !ok {
+            return
+        }
+
+        fromName := fmt.Sprintf("revision %d", from.Revision)
+        toName := fmt.Sprintf("revision %d", to.Revision)
+        diff := utils.UnifiedDiff(fromName+" title", toName+" title", from.Title, to.Title, diffContextLines) +
+            utils.UnifiedDiff(fromName+" content", toName+" content", from.Content, to.Content, diffContextLines)
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "from": from.Revision,
+            "to":   to.Revision,
+            "diff": diff,
+        })
+    }
+}
+
+// RestorePostRevision brings back an older version of a post. The restored title and
+// content are saved as a new revision, so the versions in between are kept.
+func RestorePostRevision(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        post, user, ok := revisionPost(db, w, r)
+        if !ok {
+            return
+        }
+
+        number, _ := strconv.Atoi(mux.Vars(r)["revision"])
+        revision, ok := loadRevision(db, w, post.ID, number)
+        if !ok {
+            return
+        }
+
+        post.Title = revision.Title
+        post.Content = revision.Content
+        post.UpdatedAt = time.Now()
+        err := models.UpdatePost(db, post, user.ID)
+        if err != nil {
+            fmt.Println("Error restoring revision:", err)
+            http.Error(w, "Error restoring revision", http.StatusInternalServerError)
+            return
+        }
+        reindexPost(searcher, post)
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(post)
+    }
+}
+
+// revisionPost loads the post named by the {id} route variable for a revision endpoint.
+// A post's history is only open to those who may edit it, since it can hold text
+// that was removed on purpose.
+func revisionPost(db *sql.DB, w http.ResponseWriter, r *http.Request) (*models.Post, *models.User, bool) {
+    user, ok := middleware.CurrentUser(r)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return nil, nil, false
+    }
+
+    post, err := models.GetPostByID(db, mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Post not found", http.StatusNotFound)
+        return nil, nil, false
+    }
+
+    allowed, err := canModifyPost(db, r, post, user, models.PermissionPostsUpdateOwn, models.PermissionPostsUpdateAny)
+    if err != nil {
+        fmt.Println("Error checking permissions:", err)
+        http.Error(w, "Internal server error", http.StatusInternalServerError)
+        return nil, nil, false
+    }
+    if !allowed {
+        http.Error(w, "You are not authorized to view this post's history", http.StatusForbidden)
+        return nil, nil, false
+    }
+    return post, user, true
+}
+
+func loadRevision(db *sql.DB, w http.ResponseWriter, postID, number int) (*models.PostRevision, bool) {
+    revision, err := models.GetPostRevision(db, postID, number)
+    if err == models.ErrPostRevisionNotFound {
+        http.Error(w, fmt.Sprintf("Revision %d not found", number), http.StatusNotFound)
+        return nil, false
+    }
+    if err != nil {
+        fmt.Println("Error fetching revision:", err)
+        http.Error(w, "Error fetching revision", http.StatusInternalServerError)
+        return nil, false
+    }
+    return revision, true
+}
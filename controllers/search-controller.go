@@ -0,0 +1,107 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+// searchSnippetSize is roughly how much of a post's content each result shows
+const searchSnippetSize = 200
+
+type searchResult struct {
+    Post    models.Post `json:"post"`
+    Score   float64     `json:"score"`
+    Title   string      `json:"title_highlighted"`
+    Snippet string      `json:"snippet"`
+}
+
+// SearchPosts finds posts matching ?q=, best match first, paged with ?page= and ?per_page=.
+// Titles and snippets are HTML-escaped with the matching words wrapped in <mark>.
+func SearchPosts(db *sql.DB, searcher utils.Searcher) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        q := query.Get("q")
+        terms := utils.SearchTerms(q)
+        if len(terms) == 0 {
+            http.Error(w, "q must contain at least one word", http.StatusBadRequest)
+            return
+        }
+
+        page, err := strconv.Atoi(query.Get("page"))
+        if err != nil || page < 1 {
+            page = 1
+        }
+        perPage, err := strconv.Atoi(query.Get("per_page"))
+        if err != nil || perPage < 1 {
+            perPage = models.DefaultPostPageSize
+        }
+        if perPage > models.MaxPostPageSize {
+            perPage = models.MaxPostPageSize
+        }
+        // Pages this far out are empty anyway, and their offset would overflow
+        if page-1 > math.MaxInt32/perPage {
+            http.Error(w, "page is too large", http.StatusBadRequest)
+            return
+        }
+
+        hits, total, err := searcher.Search(q, perPage, (page-1)*perPage)
+        if err != nil {
+            fmt.Println("Error searching posts:", err)
+            http.Error(w, "Error searching posts", http.StatusInternalServerError)
+            return
+        }
+
+        ids := make([]int, len(hits))
+        for i, hit := range hits {
+            ids[i] = hit.PostID
+        }
+        posts, err := models.GetPostsByIDs(db, ids)
+        if err != nil {
+            fmt.Println("Error fetching posts:", err)
+            http.Error(w, "Error searching posts", http.StatusInternalServerError)
+            return
+        }
+        byID := make(map[int]models.Post, len(posts))
+        for _, post := range posts {
+            byID[post.ID] = post
+        }
+
+        // Keep the ranking; a hit whose post was deleted or unpublished meanwhile is dropped
+        results := make([]searchResult, 0, len(hits))
+        for _, hit := range hits {
+            post, ok := byID[hit.PostID]
+            if !ok || !post.IsPublished() {
+                continue
+            }
+            results = append(results, searchResult{
+                Post:    post,
+                Score:   hit.Score,
+                Title:   utils.HighlightSearchTerms(post.Title, terms),
+                Snippet: utils.SearchSnippet(post.Content, terms, searchSnippetSize),
+            })
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "results":  results,
+            "page":     page,
+            "per_page": perPage,
+            "total":    total,
+        })
+    }
+}
+
+// reindexPost updates the search index after a post changes. The post itself is
+// already saved, so a failure is only logged.
+func reindexPost(searcher utils.Searcher, post *models.Post) {
+    err := searcher.IndexPost(post)
+    if err != nil {
+        fmt.Println("Error indexing post:", err)
+    }
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "blog-app/middleware"
+    "blog-app/models"
+    "github.com/gorilla/mux"
+)
+
+// ListSessions shows the devices the user is logged in on
+func ListSessions(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        claims, claimsOK := middleware.CurrentClaims(r)
+        if !ok || !claimsOK {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        sessions, err := models.GetActiveSessionsByUser(db, user.ID)
+        if err != nil {
+            fmt.Println("Error fetching sessions:", err)
+            http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+            return
+        }
+
+        type sessionResponse struct {
+            models.Session
+            Current bool `json:"current"`
+        }
+        response := make([]sessionResponse, 0, len(sessions))
+        for _, session := range sessions {
+            response = append(response, sessionResponse{session, session.ID == claims.SessionID})
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(response)
+    }
+}
+
+// RevokeSession logs out one device
+func RevokeSession(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        err := models.RevokeSession(db, user.ID, mux.Vars(r)["id"])
+        if err == models.ErrSessionNotFound {
+            http.Error(w, "Session not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error revoking session:", err)
+            http.Error(w, "Error revoking session", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Session revoked"})
+    }
+}
+
+// RevokeAllSessions logs out every device. With ?keep_current=true the session
+// making the request stays logged in.
+func RevokeAllSessions(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        claims, claimsOK := middleware.CurrentClaims(r)
+        if !ok || !claimsOK {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        keep := ""
+        if r.URL.Query().Get("keep_current") == "true" {
+            keep = claims.SessionID
+        }
+
+        err := models.RevokeUserSessions(db, user.ID, keep)
+        if err != nil {
+            fmt.Println("Error revoking sessions:", err)
+            http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+            return
+        }
+
+        // Revoking everything includes the token used for this request
+        if keep == "" {
+            err = models.RevokeAccessToken(db, claims.ID, claims.ExpiresAt.Time)
+            if err != nil {
+                fmt.Println("Error revoking access token:", err)
+            }
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Sessions revoked"})
+    }
+}
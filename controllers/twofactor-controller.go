@@ -0,0 +1,270 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+const (
+    mfaLoginTTL       = 5 * time.Minute
+    recoveryCodeCount = 10
+)
+
+func totpIssuer() string {
+    if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+        return issuer
+    }
+    return "Blog API"
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code
+func checkSecondFactor(db *sql.DB, user *models.User, code, recoveryCode string) (bool, error) {
+    if code != "" {
+        step, ok := utils.ValidateTOTP(user.TOTPSecret.String, code, time.Now())
+        if !ok {
+            return false, nil
+        }
+        return models.UseTOTPStep(db, user.ID, step)
+    }
+    if recoveryCode != "" {
+        hash := utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))
+        return models.UseRecoveryCode(db, user.ID, hash)
+    }
+    return false, nil
+}
+
+func EnrollTOTP(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        if user.TOTPEnabledAt != nil {
+            http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+            return
+        }
+
+        secret, err := utils.GenerateTOTPSecret()
+        if err != nil {
+            fmt.Println("Error generating TOTP secret:", err)
+            http.Error(w, "Error enrolling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+
+        err = models.SetTOTPSecret(db, user.ID, secret)
+        if err != nil {
+            fmt.Println("Error storing TOTP secret:", err)
+            http.Error(w, "Error enrolling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{
+            "secret":      secret,
+            "otpauth_uri": utils.TOTPURI(totpIssuer(), user.Email, secret),
+        })
+    }
+}
+
+func VerifyTOTP(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Code string `json:"code"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.Code == "" {
+            http.Error(w, "Code is required", http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        if user.TOTPEnabledAt != nil {
+            http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+            return
+        }
+        if !user.TOTPSecret.Valid {
+            http.Error(w, "Start enrollment with /2fa/enroll first", http.StatusBadRequest)
+            return
+        }
+
+        step, ok := utils.ValidateTOTP(user.TOTPSecret.String, req.Code, time.Now())
+        if !ok {
+            http.Error(w, "Invalid code", http.StatusUnauthorized)
+            return
+        }
+
+        codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+        if err != nil {
+            fmt.Println("Error generating recovery codes:", err)
+            http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+        hashes := make([]string, len(codes))
+        for i, code := range codes {
+            hashes[i] = utils.HashToken(code)
+        }
+
+        err = models.EnableTOTP(db, user.ID, step, hashes)
+        if err != nil {
+            fmt.Println("Error enabling TOTP:", err)
+            http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+
+        // Recovery codes are only ever shown here
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "message":        "Two-factor authentication enabled",
+            "recovery_codes": codes,
+        })
+    }
+}
+
+func DisableTOTP(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Password     string `json:"password"`
+            Code         string `json:"code"`
+            RecoveryCode string `json:"recovery_code"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        if user.TOTPEnabledAt == nil {
+            http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+            return
+        }
+
+        // Turning off a second factor needs both factors, not just a (possibly stolen)
+        // token, and guesses are throttled like /login
+        email := normalizeLoginEmail(user.Email)
+        ip := utils.ClientIP(r)
+        if loginBlocked(db, w, email, ip) {
+            return
+        }
+
+        valid, _, err := utils.VerifyPassword(user.Password, req.Password)
+        if err != nil || !valid {
+            recordLoginFailure(db, email, ip, user)
+            http.Error(w, "Invalid password", http.StatusUnauthorized)
+            return
+        }
+
+        valid, err = checkSecondFactor(db, user, req.Code, req.RecoveryCode)
+        if err != nil {
+            fmt.Println("Error checking second factor:", err)
+            http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+        if !valid {
+            recordLoginFailure(db, email, ip, user)
+            http.Error(w, "Invalid code", http.StatusUnauthorized)
+            return
+        }
+
+        err = models.DisableTOTP(db, user.ID)
+        if err != nil {
+            fmt.Println("Error disabling TOTP:", err)
+            http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+    }
+}
+
+// LoginTOTP is the second step of /login for accounts with two-factor authentication
+func LoginTOTP(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            MFAToken     string `json:"mfa_token"`
+            Code         string `json:"code"`
+            RecoveryCode string `json:"recovery_code"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.MFAToken == "" {
+            http.Error(w, "mfa_token is required", http.StatusBadRequest)
+            return
+        }
+
+        // The mfa_token allows a single attempt; after a wrong code the password step starts over
+        token, err := models.ConsumeUserToken(db, models.TokenPurposeMFALogin, utils.HashToken(req.MFAToken))
+        if err == models.ErrUserTokenInvalid {
+            http.Error(w, "Invalid or expired mfa_token, please log in again", http.StatusUnauthorized)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error consuming mfa token:", err)
+            http.Error(w, "Error logging in", http.StatusInternalServerError)
+            return
+        }
+
+        user, err := models.GetUserByID(db, token.UserID)
+        if err != nil {
+            fmt.Println("Error fetching user:", err)
+            http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+            return
+        }
+
+        // Wrong codes count towards the same lockout as wrong passwords
+        email := normalizeLoginEmail(user.Email)
+        ip := utils.ClientIP(r)
+        if loginBlocked(db, w, email, ip) {
+            return
+        }
+
+        valid, err := checkSecondFactor(db, user, req.Code, req.RecoveryCode)
+        if err != nil {
+            fmt.Println("Error checking second factor:", err)
+            http.Error(w, "Error logging in", http.StatusInternalServerError)
+            return
+        }
+        if !valid {
+            recordLoginFailure(db, email, ip, user)
+            http.Error(w, "Invalid code, please log in again", http.StatusUnauthorized)
+            return
+        }
+        recordLoginSuccess(db, email, ip)
+
+        if middleware.RejectSuspended(w, user) {
+            return
+        }
+
+        tokens, err := utils.IssueTokens(db, user, r)
+        if err != nil {
+            fmt.Println("Error generating JWT:", err)
+            http.Error(w, "Error generating token", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
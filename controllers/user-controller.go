@@ -3,13 +3,25 @@ package controllers
 import (
     "database/sql"
     "encoding/json"
+    "io"
     "net/http"
+    "regexp"
     "blog-app/models"
-    "golang.org/x/crypto/bcrypt"
     "fmt"
+    "blog-app/middleware"
     "blog-app/utils"
 )
-func Register(db *sql.DB) http.HandlerFunc {
+
+// usernameUnsafeChars matches anything not allowed in a username. Usernames for
+// single sign-on users are derived by removing these.
+var usernameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// validUsername keeps usernames safe to use in URLs such as /users/{username}
+func validUsername(username string) bool {
+    return username != "" && len(username) <= 100 && !usernameUnsafeChars.MatchString(username)
+}
+
+func Register(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         var user models.User
         err := json.NewDecoder(r.Body).Decode(&user)
@@ -19,31 +31,48 @@ func Register(db *sql.DB) http.HandlerFunc {
             return
         }
 
-        // Validate the password length
-        // reg = "^(?=.*[A-Za-z])(?=.*\d)[A-Za-z\d]{8,}$"
-        if len(user.Password) < 6 {
-            fmt.Println("Password too short:", user.Password)
-            http.Error(w, "Password must be at least 6 characters long", http.StatusBadRequest)
+        if !validUsername(user.Username) {
+            http.Error(w, "Username is required and may only contain letters, digits, '.', '_' and '-'", http.StatusBadRequest)
+            return
+        }
+
+        if models.IsReservedEmail(user.Email) {
+            http.Error(w, "This email address can't be used", http.StatusBadRequest)
+            return
+        }
+
+        // Validate the password against the password policy
+        if rejectWeakPassword(w, user.Password, user.Username, user.Email) {
             return
         }
 
         // Hash the password
-        hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+        hashedPassword, err := utils.HashPassword(user.Password)
         if err != nil {
             fmt.Println("Error hashing password:", err)
             http.Error(w, "Error creating user", http.StatusInternalServerError)
             return
         }
-        user.Password = string(hashedPassword)
+        user.Password = hashedPassword
 
         // Create the user in the database
         err = user.CreateUser(db)
+        if models.IsDuplicateKey(err) {
+            http.Error(w, "Username or email is already taken", http.StatusConflict)
+            return
+        }
         if err != nil {
             fmt.Println("Error creating user in database:", err)
             http.Error(w, "Error creating user", http.StatusInternalServerError)
             return
         }
 
+        // A failed email shouldn't fail the signup; the user can ask for another one
+        err = sendVerificationEmail(db, mailer, &user)
+        if err != nil {
+            fmt.Println("Error sending verification email:", err)
+        }
+
         // Return a success response
         fmt.Println("User registered successfully:", user)
         w.WriteHeader(http.StatusCreated)
@@ -61,33 +90,178 @@ func Login(db *sql.DB) http.HandlerFunc {
             return
         }
 
+        // Refuse to check the password while the account or address is locked out
+        email := normalizeLoginEmail(loginRequest.Email)
+        ip := utils.ClientIP(r)
+        if loginBlocked(db, w, email, ip) {
+            return
+        }
+
         // Fetch user by email
         storedUser, err := models.GetUserByEmail(db, loginRequest.Email)
         if err != nil {
             fmt.Println("Error fetching user by email:", err)
+            recordLoginFailure(db, email, ip, nil)
             http.Error(w, "Invalid email or password", http.StatusUnauthorized)
             return
         }
 
         // Compare the hashed password
-        err = bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(loginRequest.Password))
-        if err != nil {
+        valid, needsRehash, err := utils.VerifyPassword(storedUser.Password, loginRequest.Password)
+        if err != nil || !valid {
             fmt.Println("Password comparison failed:", err)
+            recordLoginFailure(db, email, ip, storedUser)
             http.Error(w, "Invalid email or password", http.StatusUnauthorized)
             return
         }
 
-        // Generate JWT token
-        token, err := utils.GenerateJWT(storedUser)
+        // Upgrade hashes made with an older algorithm or cost while we have the plain password
+        if needsRehash {
+            rehashPassword(db, storedUser, loginRequest.Password)
+        }
+
+        // With two-factor enabled the login only counts as successful after /login/2fa
+        if storedUser.TOTPEnabledAt == nil {
+            recordLoginSuccess(db, email, ip)
+        }
+        completeLogin(db, w, r, storedUser)
+    }
+}
+
+// completeLogin finishes a first-factor login: it either issues tokens or, for
+// accounts with two-factor authentication, asks for a code via /login/2fa
+func completeLogin(db *sql.DB, w http.ResponseWriter, r *http.Request, user *models.User) {
+    if middleware.RejectSuspended(w, user) {
+        return
+    }
+
+    if user.TOTPEnabledAt != nil {
+        mfaToken, err := utils.CreateUserToken(db, user.ID, models.TokenPurposeMFALogin, mfaLoginTTL)
         if err != nil {
-            fmt.Println("Error generating JWT:", err)
+            fmt.Println("Error creating mfa token:", err)
             http.Error(w, "Error generating token", http.StatusInternalServerError)
             return
         }
 
-        // Send the token in the response
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "mfa_required": true,
+            "mfa_token":    mfaToken,
+        })
+        return
+    }
+
+    // Generate the access and refresh tokens
+    tokens, err := utils.IssueTokens(db, user, r)
+    if err != nil {
+        fmt.Println("Error generating JWT:", err)
+        http.Error(w, "Error generating token", http.StatusInternalServerError)
+        return
+    }
+
+    // Send the tokens in the response
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(tokens)
+}
+
+// rehashPassword stores the password hashed with the configured algorithm. Failures are
+// only logged; the old hash keeps working and the upgrade is retried on the next login.
+func rehashPassword(db *sql.DB, user *models.User, password string) {
+    hash, err := utils.HashPassword(password)
+    if err != nil {
+        fmt.Println("Error rehashing password:", err)
+        return
+    }
+    err = models.RehashPassword(db, user.ID, user.Password, hash)
+    if err != nil {
+        fmt.Println("Error storing rehashed password:", err)
+        return
+    }
+    user.Password = hash
+}
+
+func RefreshToken(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            RefreshToken string `json:"refresh_token"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.RefreshToken == "" {
+            http.Error(w, "refresh_token is required", http.StatusBadRequest)
+            return
+        }
+
+        // The old refresh token is revoked and replaced by a new one
+        tokens, err := utils.RefreshTokens(db, req.RefreshToken, r)
+        if err == utils.ErrInvalidRefreshToken {
+            http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+            return
+        }
+        if err == utils.ErrAccountSuspended {
+            http.Error(w, "Your account has been suspended", http.StatusForbidden)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error refreshing token:", err)
+            http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
+
+func Logout(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        claims, claimsOK := middleware.CurrentClaims(r)
+        if !ok || !claimsOK {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        // The refresh token is optional; tokens issued before sessions existed need it to end the login
+        var req struct {
+            RefreshToken string `json:"refresh_token"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil && err != io.EOF {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+
+        err = models.RevokeAccessToken(db, claims.ID, claims.ExpiresAt.Time)
+        if err != nil {
+            fmt.Println("Error revoking access token:", err)
+            http.Error(w, "Error logging out", http.StatusInternalServerError)
+            return
+        }
+
+        // Ending the session also revokes its refresh tokens
+        if claims.SessionID != "" {
+            err = models.RevokeSession(db, user.ID, claims.SessionID)
+            if err != nil && err != models.ErrSessionNotFound {
+                fmt.Println("Error revoking session:", err)
+                http.Error(w, "Error logging out", http.StatusInternalServerError)
+                return
+            }
+        }
+
+        if req.RefreshToken != "" {
+            err = utils.RevokeRefreshToken(db, user.ID, req.RefreshToken)
+            if err != nil && err != utils.ErrInvalidRefreshToken {
+                fmt.Println("Error revoking refresh token:", err)
+                http.Error(w, "Error logging out", http.StatusInternalServerError)
+                return
+            }
+        }
+
         w.WriteHeader(http.StatusOK)
-        json.NewEncoder(w).Encode(map[string]string{"token": token})
+        json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
     }
 }
 
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// requireVerifiedEmail reports whether unverified accounts are blocked from posting (REQUIRE_VERIFIED_EMAIL)
+func requireVerifiedEmail() bool {
+    return utils.GetEnvBool("REQUIRE_VERIFIED_EMAIL", false)
+}
+
+// sendVerificationEmail emails a fresh verification link, invalidating any earlier ones
+func sendVerificationEmail(db *sql.DB, mailer utils.Mailer, user *models.User) error {
+    err := models.InvalidateUserTokens(db, user.ID, models.TokenPurposeEmailVerification)
+    if err != nil {
+        return err
+    }
+
+    token, err := utils.CreateUserToken(db, user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+    if err != nil {
+        return err
+    }
+
+    link := utils.AppURL("/verify-email?token=" + url.QueryEscape(token))
+    body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link within 48 hours:\n\n%s\n", user.Name, link)
+    return mailer.Send(user.Email, "Verify your email address", body)
+}
+
+func VerifyEmail(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        token := r.URL.Query().Get("token")
+        if token == "" {
+            http.Error(w, "Token is required", http.StatusBadRequest)
+            return
+        }
+
+        userToken, err := models.ConsumeUserToken(db, models.TokenPurposeEmailVerification, utils.HashToken(token))
+        if err == models.ErrUserTokenInvalid {
+            http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error consuming verification token:", err)
+            http.Error(w, "Error verifying email", http.StatusInternalServerError)
+            return
+        }
+
+        err = models.MarkEmailVerified(db, userToken.UserID)
+        if err != nil {
+            fmt.Println("Error marking email verified:", err)
+            http.Error(w, "Error verifying email", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully"})
+    }
+}
+
+func ResendVerificationEmail(db *sql.DB, mailer utils.Mailer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        if user.EmailVerifiedAt != nil {
+            http.Error(w, "Email is already verified", http.StatusConflict)
+            return
+        }
+
+        err := sendVerificationEmail(db, mailer, user)
+        if err != nil {
+            fmt.Println("Error sending verification email:", err)
+            http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+    }
+}
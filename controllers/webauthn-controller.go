@@ -0,0 +1,335 @@
+package controllers
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
+    "github.com/gorilla/mux"
+)
+
+const webAuthnChallengeTTL = 5 * time.Minute
+
+type credentialDescriptor struct {
+    Type string `json:"type"`
+    ID   string `json:"id"`
+}
+
+func credentialDescriptors(credentials []models.WebAuthnCredential) []credentialDescriptor {
+    descriptors := []credentialDescriptor{}
+    for _, credential := range credentials {
+        descriptors = append(descriptors, credentialDescriptor{
+            Type: "public-key",
+            ID:   base64.RawURLEncoding.EncodeToString(credential.CredentialID),
+        })
+    }
+    return descriptors
+}
+
+func BeginPasskeyRegistration(db *sql.DB, config utils.WebAuthnConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        existing, err := models.GetWebAuthnCredentialsByUser(db, user.ID)
+        if err != nil {
+            fmt.Println("Error fetching passkeys:", err)
+            http.Error(w, "Error starting passkey registration", http.StatusInternalServerError)
+            return
+        }
+
+        // The challenge is stored like any other one-time token and echoed back in clientDataJSON
+        challenge, err := utils.CreateUserToken(db, user.ID, models.TokenPurposeWebAuthnRegister, webAuthnChallengeTTL)
+        if err != nil {
+            fmt.Println("Error creating challenge:", err)
+            http.Error(w, "Error starting passkey registration", http.StatusInternalServerError)
+            return
+        }
+
+        options := map[string]interface{}{
+            "challenge": challenge,
+            "rp":        map[string]string{"id": config.RPID, "name": config.RPName},
+            "user": map[string]string{
+                "id":          base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(user.ID))),
+                "name":        user.Email,
+                "displayName": user.Name,
+            },
+            "pubKeyCredParams": []map[string]interface{}{
+                {"type": "public-key", "alg": utils.COSEAlgES256},
+                {"type": "public-key", "alg": utils.COSEAlgEdDSA},
+                {"type": "public-key", "alg": utils.COSEAlgRS256},
+            },
+            "timeout":            webAuthnChallengeTTL.Milliseconds(),
+            "attestation":        "none",
+            "excludeCredentials": credentialDescriptors(existing),
+            "authenticatorSelection": map[string]string{
+                "residentKey":      "preferred",
+                "userVerification": "required",
+            },
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]interface{}{"publicKey": options})
+    }
+}
+
+func FinishPasskeyRegistration(db *sql.DB, config utils.WebAuthnConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Name       string                           `json:"name"`
+            Credential utils.CredentialCreationResponse `json:"credential"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        challenge, err := utils.ChallengeFromClientData(req.Credential.Response.ClientDataJSON)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        token, err := models.ConsumeUserToken(db, models.TokenPurposeWebAuthnRegister, utils.HashToken(challenge))
+        if err != nil || token.UserID != user.ID {
+            http.Error(w, "Invalid or expired challenge", http.StatusBadRequest)
+            return
+        }
+
+        registered, err := config.VerifyRegistration(&req.Credential, challenge)
+        if err != nil {
+            fmt.Println("Passkey registration failed:", err)
+            http.Error(w, "Passkey registration failed: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        exists, err := models.WebAuthnCredentialExists(db, registered.ID)
+        if err != nil {
+            fmt.Println("Error checking passkey:", err)
+            http.Error(w, "Error registering passkey", http.StatusInternalServerError)
+            return
+        }
+        if exists {
+            http.Error(w, "This passkey is already registered", http.StatusConflict)
+            return
+        }
+
+        if req.Name == "" {
+            req.Name = "Passkey"
+        }
+        credential := &models.WebAuthnCredential{
+            UserID:       user.ID,
+            CredentialID: registered.ID,
+            PublicKey:    registered.PublicKey,
+            SignCount:    registered.SignCount,
+            Name:         req.Name,
+        }
+        err = models.CreateWebAuthnCredential(db, credential)
+        if err != nil {
+            fmt.Println("Error storing passkey:", err)
+            http.Error(w, "Error registering passkey", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(credential)
+    }
+}
+
+func ListPasskeys(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        credentials, err := models.GetWebAuthnCredentialsByUser(db, user.ID)
+        if err != nil {
+            fmt.Println("Error fetching passkeys:", err)
+            http.Error(w, "Error fetching passkeys", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(credentials)
+    }
+}
+
+func DeletePasskey(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user, ok := middleware.CurrentUser(r)
+        if !ok {
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        id, err := strconv.Atoi(mux.Vars(r)["id"])
+        if err != nil {
+            http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+            return
+        }
+
+        err = models.DeleteWebAuthnCredential(db, user.ID, id)
+        if err == models.ErrWebAuthnCredentialNotFound {
+            http.Error(w, "Passkey not found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            fmt.Println("Error deleting passkey:", err)
+            http.Error(w, "Error deleting passkey", http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]string{"message": "Passkey deleted successfully"})
+    }
+}
+
+func BeginPasskeyLogin(db *sql.DB, config utils.WebAuthnConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Email string `json:"email"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil || req.Email == "" {
+            http.Error(w, "Email is required", http.StatusBadRequest)
+            return
+        }
+
+        // Unknown emails get a challenge that can never be completed, and every email
+        // without passkeys gets decoy credentials, so the response looks the same
+        // whether or not the account exists or has passkeys
+        challenge, err := utils.GenerateRandomToken(32)
+        if err != nil {
+            fmt.Println("Error creating challenge:", err)
+            http.Error(w, "Error starting passkey login", http.StatusInternalServerError)
+            return
+        }
+        credentials := []models.WebAuthnCredential{}
+
+        user, err := models.GetUserByEmail(db, req.Email)
+        if err == nil {
+            credentials, err = models.GetWebAuthnCredentialsByUser(db, user.ID)
+            if err != nil {
+                fmt.Println("Error fetching passkeys:", err)
+                http.Error(w, "Error starting passkey login", http.StatusInternalServerError)
+                return
+            }
+            challenge, err = utils.CreateUserToken(db, user.ID, models.TokenPurposeWebAuthnLogin, webAuthnChallengeTTL)
+            if err != nil {
+                fmt.Println("Error creating challenge:", err)
+                http.Error(w, "Error starting passkey login", http.StatusInternalServerError)
+                return
+            }
+        }
+
+        descriptors := credentialDescriptors(credentials)
+        if len(descriptors) == 0 {
+            for _, id := range config.DecoyCredentialIDs(req.Email) {
+                descriptors = append(descriptors, credentialDescriptor{
+                    Type: "public-key",
+                    ID:   base64.RawURLEncoding.EncodeToString(id),
+                })
+            }
+        }
+
+        options := map[string]interface{}{
+            "challenge":        challenge,
+            "rpId":             config.RPID,
+            "timeout":          webAuthnChallengeTTL.Milliseconds(),
+            "allowCredentials": descriptors,
+            "userVerification": "required",
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(map[string]interface{}{"publicKey": options})
+    }
+}
+
+func FinishPasskeyLogin(db *sql.DB, config utils.WebAuthnConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Credential utils.CredentialAssertionResponse `json:"credential"`
+        }
+        err := json.NewDecoder(r.Body).Decode(&req)
+        if err != nil {
+            http.Error(w, "Invalid request payload", http.StatusBadRequest)
+            return
+        }
+
+        challenge, err := utils.ChallengeFromClientData(req.Credential.Response.ClientDataJSON)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        token, err := models.ConsumeUserToken(db, models.TokenPurposeWebAuthnLogin, utils.HashToken(challenge))
+        if err != nil {
+            http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+            return
+        }
+
+        credentialID, err := utils.DecodeWebAuthnBase64(req.Credential.RawID)
+        if err != nil {
+            http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+            return
+        }
+        credential, err := models.GetWebAuthnCredential(db, token.UserID, credentialID)
+        if err != nil {
+            http.Error(w, "Unknown passkey", http.StatusUnauthorized)
+            return
+        }
+
+        signCount, err := config.VerifyAssertion(&req.Credential, challenge, credential.PublicKey, credential.SignCount)
+        if err != nil {
+            fmt.Println("Passkey login failed:", err)
+            http.Error(w, "Passkey login failed", http.StatusUnauthorized)
+            return
+        }
+
+        err = models.UpdateWebAuthnCredentialUsage(db, credential.ID, signCount)
+        if err != nil {
+            fmt.Println("Error updating passkey:", err)
+        }
+
+        user, err := models.GetUserByID(db, token.UserID)
+        if err != nil {
+            http.Error(w, "User not found", http.StatusUnauthorized)
+            return
+        }
+
+        if middleware.RejectSuspended(w, user) {
+            return
+        }
+
+        // Same tokens as a password login
+        tokens, err := utils.IssueTokens(db, user, r)
+        if err != nil {
+            fmt.Println("Error generating JWT:", err)
+            http.Error(w, "Error generating token", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        json.NewEncoder(w).Encode(tokens)
+    }
+}
@@ -5,7 +5,12 @@ import (
     "fmt"
     "log"
     "net/http"
+    "strings"
+    "time"
+    "blog-app/models"
     "blog-app/routers"
+    "blog-app/utils"
+    "blog-app/workers"
     _ "github.com/go-sql-driver/mysql"
     "os"
     "github.com/joho/godotenv"
@@ -17,6 +22,17 @@ func main() {
     
     err := godotenv.Load()
 
+    // Fail fast on a broken key configuration instead of on the first login
+    _, err = utils.LoadSigningKeys()
+    if err != nil {
+        log.Fatalf("Error loading JWT signing keys: %v", err)
+    }
+
+    _, err = utils.LoadPasswordPolicy()
+    if err != nil {
+        log.Fatalf("Error loading password policy: %v", err)
+    }
+
     rdsEndpoint := os.Getenv("RDS_ENDPOINT")
     rdsPort := os.Getenv("RDS_PORT")
     dbUser := os.Getenv("DB_USER")
@@ -70,6 +86,93 @@ func main() {
         log.Fatal(err)
     }
 
+    err = ensureColumn(db, "users", "email_verified_at", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Two-factor authentication; totp_last_step stops a code from being used twice
+    err = ensureColumn(db, "users", "totp_secret", "VARCHAR(64) NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "totp_enabled_at", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "totp_last_step", "BIGINT NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Users provisioned through single sign-on, keyed by the provider's subject claim
+    err = ensureColumn(db, "users", "oidc_issuer", "VARCHAR(255) NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "oidc_subject", "VARCHAR(255) NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureIndex(db, "users", "UNIQUE", "uniq_users_oidc_identity", "oidc_issuer, oidc_subject")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Access tokens issued before this time are rejected
+    err = ensureColumn(db, "users", "password_changed_at", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Shown on the public author page
+    err = ensureColumn(db, "users", "bio", "VARCHAR(500) NOT NULL DEFAULT ''")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Posts are attributed by username, so two accounts must never share one
+    err = ensureIndex(db, "users", "UNIQUE", "uniq_users_username", "username")
+    if err != nil {
+        log.Fatalf("Error adding unique index on users.username, rename duplicate usernames first: %v", err)
+    }
+
+    // Suspensions by admins; a NULL suspended_until means until lifted
+    err = ensureColumn(db, "users", "suspended_at", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "suspended_until", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "suspension_reason", "VARCHAR(500) NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "users", "suspended_by", "INT NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // The account anonymized posts are moved to. Only that row is TRUE and every other
+    // one NULL, so the unique index allows a single anonymous account.
+    err = ensureColumn(db, "users", "is_anonymous", "BOOLEAN NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureIndex(db, "users", "UNIQUE", "uniq_users_anonymous", "is_anonymous")
+    if err != nil {
+        log.Fatal(err)
+    }
+    // It used to be found by its email alone. Only an account without a password or
+    // identity provider can be the one we created; anyone else who registered the
+    // address is left alone.
+    _, err = db.Exec(`UPDATE users SET is_anonymous = TRUE WHERE email = 'anonymous@deleted.invalid' AND password = '!' AND oidc_issuer IS NULL`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
     fmt.Println("Database 'blog_api_go' and table 'users' created successfully.")
 
     _, err = db.Exec(`
@@ -87,13 +190,434 @@ if err != nil {
     log.Fatal(err)
 }
 
+    // Posts belong to a user by id. username is no longer written; it only names the author
+    // of old posts that couldn't be matched to an account.
+    err = ensureColumn(db, "blogs", "author_id", "INT NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    _, err = db.Exec(`UPDATE blogs b JOIN users u ON u.username = b.username SET b.author_id = u.id WHERE b.author_id IS NULL`)
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureNullable(db, "blogs", "username", "VARCHAR(255) NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureForeignKey(db, "blogs", "fk_blogs_author", "FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE SET NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    // Posts can be drafted or scheduled; existing posts were all public
+    err = ensureColumn(db, "blogs", "status", "VARCHAR(16) NOT NULL DEFAULT 'published'")
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = ensureColumn(db, "blogs", "publish_at", "DATETIME NULL")
+    if err != nil {
+        log.Fatal(err)
+    }
+    _, err = db.Exec(`UPDATE blogs SET publish_at = created_at WHERE status = 'published' AND publish_at IS NULL`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Back the sort orders and filters of the /posts listing, each ending in id for cursor pagination
+    postIndexes := []struct{ name, columns string }{
+        {"idx_blogs_created_id", "created_at, id"},
+        {"idx_blogs_updated_id", "updated_at, id"},
+        {"idx_blogs_title_id", "title, id"},
+        {"idx_blogs_author_created_id", "author_id, created_at, id"},
+        {"idx_blogs_status_created_id", "status, created_at, id"},
+    }
+    for _, index := range postIndexes {
+        err = ensureIndex(db, "blogs", "", index.name, index.columns)
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+    // Lets the publisher find due scheduled posts
+    err = ensureIndex(db, "blogs", "", "idx_blogs_status_publish_at", "status, publish_at")
+    if err != nil {
+        log.Fatal(err)
+    }
+    // Used by the mysql search backend
+    err = ensureIndex(db, "blogs", "FULLTEXT", "ft_blogs_title_content", "title, content")
+    if err != nil {
+        log.Fatal(err)
+    }
+
 port := os.Getenv("PORT")
 if port == "" {
     port = "8080"
 }
 
 fmt.Println("Table 'blogs' created successfully.")
-    router := routers.InitRouter(db)
+
+    // Every saved version of a post's title and content
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS post_revisions (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        post_id INT NOT NULL,
+        revision INT NOT NULL,
+        title VARCHAR(255) NOT NULL,
+        content TEXT NOT NULL,
+        author_id INT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE KEY uniq_post_revisions_post_revision (post_id, revision),
+        FOREIGN KEY (post_id) REFERENCES blogs(id) ON DELETE CASCADE,
+        FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE SET NULL
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println("Table 'post_revisions' created successfully.")
+
+    // Refresh tokens are stored hashed; family_id groups the rotations of a single login
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS refresh_tokens (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        family_id CHAR(32) NOT NULL,
+        token_hash CHAR(64) UNIQUE NOT NULL,
+        expires_at DATETIME NOT NULL,
+        revoked_at DATETIME NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_refresh_tokens_family (family_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Access tokens revoked before expiry, keyed by their jti claim
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS revoked_tokens (
+        jti VARCHAR(64) PRIMARY KEY,
+        expires_at DATETIME NOT NULL,
+        revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+
+    // One row per login; the id is the family_id of the login's refresh tokens
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS sessions (
+        id CHAR(32) PRIMARY KEY,
+        user_id INT NOT NULL,
+        user_agent VARCHAR(255) NOT NULL DEFAULT '',
+        ip VARCHAR(45) NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL,
+        last_seen_at DATETIME NOT NULL,
+        revoked_at DATETIME NULL,
+        INDEX idx_sessions_user (user_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Tables 'refresh_tokens', 'revoked_tokens' and 'sessions' created successfully.")
+
+    // Single-use tokens emailed to users, e.g. for password resets; stored hashed
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS user_tokens (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        purpose VARCHAR(32) NOT NULL,
+        token_hash CHAR(64) UNIQUE NOT NULL,
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_user_tokens_user_purpose (user_id, purpose),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Table 'user_tokens' created successfully.")
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        code_hash CHAR(64) NOT NULL,
+        used_at DATETIME NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_totp_recovery_codes_user (user_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Table 'totp_recovery_codes' created successfully.")
+
+    // Passkeys; public_key is the COSE key from the authenticator
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS webauthn_credentials (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        credential_id VARBINARY(1023) NOT NULL,
+        public_key BLOB NOT NULL,
+        sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+        name VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        last_used_at DATETIME NULL,
+        UNIQUE KEY uniq_webauthn_credential (credential_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Table 'webauthn_credentials' created successfully.")
+
+    // Personal access tokens for scripts; scopes is a space-separated list
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS personal_access_tokens (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        token_prefix VARCHAR(32) NOT NULL,
+        token_hash CHAR(64) UNIQUE NOT NULL,
+        scopes VARCHAR(255) NOT NULL,
+        last_used_at DATETIME NULL,
+        expires_at DATETIME NULL,
+        revoked_at DATETIME NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_personal_access_tokens_user (user_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Table 'personal_access_tokens' created successfully.")
+
+    // Role-based access control
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS roles (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        name VARCHAR(50) UNIQUE NOT NULL
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS permissions (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        name VARCHAR(50) UNIQUE NOT NULL
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS role_permissions (
+        role_id INT NOT NULL,
+        permission_id INT NOT NULL,
+        PRIMARY KEY (role_id, permission_id),
+        FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+        FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS user_roles (
+        user_id INT NOT NULL,
+        role_id INT NOT NULL,
+        assigned_by INT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, role_id),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    err = models.SeedRoles(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // ADMIN_EMAILS bootstraps the first administrators
+    for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+        email = strings.TrimSpace(email)
+        if email == "" {
+            continue
+        }
+        err = models.GrantRoleByEmail(db, email, models.RoleAdmin)
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    fmt.Println("Tables 'roles', 'permissions', 'role_permissions' and 'user_roles' created successfully.")
+
+    // Brute-force protection and the audit log
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS login_attempts (
+        id BIGINT AUTO_INCREMENT PRIMARY KEY,
+        email VARCHAR(255) NOT NULL,
+        ip VARCHAR(45) NOT NULL,
+        success BOOLEAN NOT NULL,
+        created_at DATETIME NOT NULL,
+        INDEX idx_login_attempts_email (email, created_at),
+        INDEX idx_login_attempts_ip (ip, created_at)
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS login_lockouts (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        scope VARCHAR(10) NOT NULL,
+        lock_key VARCHAR(255) NOT NULL,
+        locked_until DATETIME NOT NULL,
+        created_at DATETIME NOT NULL,
+        cleared_at DATETIME NULL,
+        cleared_by INT NULL,
+        INDEX idx_login_lockouts_key (scope, lock_key, locked_until)
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS audit_log (
+        id BIGINT AUTO_INCREMENT PRIMARY KEY,
+        actor_user_id INT NULL,
+        action VARCHAR(50) NOT NULL,
+        target_user_id INT NULL,
+        ip VARCHAR(45) NOT NULL DEFAULT '',
+        details TEXT,
+        created_at DATETIME NOT NULL,
+        INDEX idx_audit_log_target (target_user_id, created_at),
+        INDEX idx_audit_log_action (action, created_at)
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Tables 'login_attempts', 'login_lockouts' and 'audit_log' created successfully.")
+
+    // Accounts waiting out their deletion grace period
+    _, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS account_deletions (
+        user_id INT PRIMARY KEY,
+        mode VARCHAR(20) NOT NULL,
+        requested_at DATETIME NOT NULL,
+        scheduled_for DATETIME NOT NULL,
+        INDEX idx_account_deletions_scheduled (scheduled_for),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )
+`)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Println("Table 'account_deletions' created successfully.")
+
+    searcher, err := utils.NewSearcherFromEnv(db)
+    if err != nil {
+        log.Fatalf("Error setting up search: %v", err)
+    }
+
+    // Background jobs
+    workers.StartAccountDeletionWorker(db, searcher, utils.GetEnvDuration("ACCOUNT_DELETION_CHECK_INTERVAL", time.Hour))
+    workers.StartPostPublisher(db, searcher, utils.GetEnvDuration("POST_PUBLISH_CHECK_INTERVAL", time.Minute))
+
+    router := routers.InitRouter(db, searcher)
     fmt.Printf("Server started at http://localhost:%s\n", port)
     log.Fatal(http.ListenAndServe(":"+port, router))
 }
+
+// ensureColumn adds a column to an existing table. MySQL has no ADD COLUMN IF NOT EXISTS,
+// so tables created by older versions are upgraded by checking information_schema first.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+    var count int
+    err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+        table, column).Scan(&count)
+    if err != nil {
+        return err
+    }
+    if count > 0 {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+    return err
+}
+
+// ensureIndex adds an index to an existing table if no index with that name exists yet.
+// kind is "", "UNIQUE" or "FULLTEXT".
+func ensureIndex(db *sql.DB, table, kind, name, columns string) error {
+    var count int
+    err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`,
+        table, name).Scan(&count)
+    if err != nil {
+        return err
+    }
+    if count > 0 {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s INDEX %s (%s)", table, kind, name, columns))
+    return err
+}
+
+// ensureNullable changes a NOT NULL column to the given nullable definition
+func ensureNullable(db *sql.DB, table, column, definition string) error {
+    var nullable string
+    err := db.QueryRow(`SELECT IS_NULLABLE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+        table, column).Scan(&nullable)
+    if err != nil {
+        return err
+    }
+    if nullable == "YES" {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", table, column, definition))
+    return err
+}
+
+// ensureForeignKey adds a named foreign key constraint if the table doesn't have it yet
+func ensureForeignKey(db *sql.DB, table, name, definition string) error {
+    var count int
+    err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.TABLE_CONSTRAINTS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ? AND CONSTRAINT_TYPE = 'FOREIGN KEY'`,
+        table, name).Scan(&count)
+    if err != nil {
+        return err
+    }
+    if count > 0 {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table, name, definition))
+    return err
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+type contextKey string
+
+const (
+    userContextKey                contextKey = "user"
+    claimsContextKey              contextKey = "claims"
+    personalAccessTokenContextKey contextKey = "personal_access_token"
+)
+
+// RequireAuth checks the "Authorization: Bearer <token>" header and stores the
+// authenticated user in the request context, together with either the JWT
+// claims or, for personal access tokens, the token record.
+func RequireAuth(db *sql.DB) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            tokenString, ok := bearerToken(r)
+            if !ok {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="blog-app"`)
+                http.Error(w, "Missing or malformed Authorization header", http.StatusUnauthorized)
+                return
+            }
+
+            if strings.HasPrefix(tokenString, utils.PersonalAccessTokenPrefix) {
+                authenticatePersonalAccessToken(db, w, r, next, tokenString)
+                return
+            }
+
+            claims, err := utils.ValidateJWT(db, tokenString)
+            if err != nil {
+                fmt.Println("Error validating JWT:", err)
+                w.Header().Set("WWW-Authenticate", `Bearer realm="blog-app", error="invalid_token"`)
+                http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+                return
+            }
+
+            user, err := models.GetUserByID(db, claims.UserID)
+            if err != nil {
+                fmt.Println("Error fetching authenticated user:", err)
+                http.Error(w, "User not found", http.StatusUnauthorized)
+                return
+            }
+
+            if RejectSuspended(w, user) {
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), userContextKey, user)
+            ctx = context.WithValue(ctx, claimsContextKey, claims)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+// OptionalAuth authenticates the request like RequireAuth when it carries an
+// Authorization header and lets it through anonymously otherwise, for public
+// endpoints that show more to signed-in users
+func OptionalAuth(db *sql.DB) func(http.Handler) http.Handler {
+    requireAuth := RequireAuth(db)
+    return func(next http.Handler) http.Handler {
+        authenticated := requireAuth(next)
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if r.Header.Get("Authorization") == "" {
+                next.ServeHTTP(w, r)
+                return
+            }
+            authenticated.ServeHTTP(w, r)
+        })
+    }
+}
+
+func authenticatePersonalAccessToken(db *sql.DB, w http.ResponseWriter, r *http.Request, next http.Handler, tokenString string) {
+    token, err := models.GetActivePersonalAccessTokenByHash(db, utils.HashToken(tokenString))
+    if err != nil {
+        if err != models.ErrPersonalAccessTokenNotFound {
+            fmt.Println("Error fetching personal access token:", err)
+        }
+        w.Header().Set("WWW-Authenticate", `Bearer realm="blog-app", error="invalid_token"`)
+        http.Error(w, "Invalid, expired or revoked token", http.StatusUnauthorized)
+        return
+    }
+
+    user, err := models.GetUserByID(db, token.UserID)
+    if err != nil {
+        fmt.Println("Error fetching authenticated user:", err)
+        http.Error(w, "User not found", http.StatusUnauthorized)
+        return
+    }
+
+    if RejectSuspended(w, user) {
+        return
+    }
+
+    err = models.TouchPersonalAccessToken(db, token.ID)
+    if err != nil {
+        fmt.Println("Error updating personal access token:", err)
+    }
+
+    ctx := context.WithValue(r.Context(), userContextKey, user)
+    ctx = context.WithValue(ctx, personalAccessTokenContextKey, token)
+    next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RejectSuspended writes a 403 and returns true when the user's account is suspended
+func RejectSuspended(w http.ResponseWriter, user *models.User) bool {
+    if !user.IsSuspended(time.Now()) {
+        return false
+    }
+
+    message := "Your account has been suspended"
+    if user.SuspendedUntil != nil {
+        message += " until " + user.SuspendedUntil.Format(time.RFC3339)
+    }
+    if user.SuspensionReason.Valid && user.SuspensionReason.String != "" {
+        message += ": " + user.SuspensionReason.String
+    }
+    http.Error(w, message, http.StatusForbidden)
+    return true
+}
+
+// RequireScope limits personal access tokens to routes covered by their scopes.
+// Logins with a JWT are not restricted.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            token, ok := CurrentPersonalAccessToken(r)
+            if ok && !token.HasScope(scope) {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="blog-app", error="insufficient_scope", scope="`+scope+`"`)
+                http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// RequireSession rejects personal access tokens, for account security endpoints
+// that should only be reachable from an interactive login
+func RequireSession(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, ok := CurrentPersonalAccessToken(r); ok {
+            http.Error(w, "This endpoint can't be used with a personal access token", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// CurrentUser returns the user stored in the context by RequireAuth.
+func CurrentUser(r *http.Request) (*models.User, bool) {
+    user, ok := r.Context().Value(userContextKey).(*models.User)
+    return user, ok
+}
+
+// CurrentClaims returns the token claims stored in the context by RequireAuth.
+func CurrentClaims(r *http.Request) (*utils.Claims, bool) {
+    claims, ok := r.Context().Value(claimsContextKey).(*utils.Claims)
+    return claims, ok
+}
+
+// CurrentPersonalAccessToken returns the token record when the request used a personal access token
+func CurrentPersonalAccessToken(r *http.Request) (*models.PersonalAccessToken, bool) {
+    token, ok := r.Context().Value(personalAccessTokenContextKey).(*models.PersonalAccessToken)
+    return token, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+    header := r.Header.Get("Authorization")
+    scheme, token, found := strings.Cut(header, " ")
+    if !found || !strings.EqualFold(scheme, "Bearer") {
+        return "", false
+    }
+    token = strings.TrimSpace(token)
+    return token, token != ""
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "net/http"
+    "blog-app/models"
+)
+
+const permissionsContextKey contextKey = "permissions"
+
+// RequirePermission only lets users through whose roles grant the permission.
+// It must run after RequireAuth.
+func RequirePermission(db *sql.DB, permission string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user, ok := CurrentUser(r)
+            if !ok {
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+
+            permissions, err := models.GetUserPermissions(db, user.ID)
+            if err != nil {
+                fmt.Println("Error fetching permissions:", err)
+                http.Error(w, "Internal server error", http.StatusInternalServerError)
+                return
+            }
+            if !permissions[permission] {
+                http.Error(w, "You don't have the "+permission+" permission", http.StatusForbidden)
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+// HasPermission reports whether the current user's roles grant the permission,
+// for checks that depend on the resource, like editing someone else's post
+func HasPermission(db *sql.DB, r *http.Request, permission string) (bool, error) {
+    if permissions, ok := r.Context().Value(permissionsContextKey).(map[string]bool); ok {
+        return permissions[permission], nil
+    }
+
+    user, ok := CurrentUser(r)
+    if !ok {
+        return false, nil
+    }
+    permissions, err := models.GetUserPermissions(db, user.ID)
+    if err != nil {
+        return false, err
+    }
+    return permissions[permission], nil
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// What happens to a deleted account's posts
+const (
+    DeletionModeAnonymize = "anonymize"
+    DeletionModeDelete    = "delete"
+)
+
+// Audit log actions for account deletion
+const (
+    AuditAccountDeletionRequested = "account.deletion_requested"
+    AuditAccountDeletionCancelled = "account.deletion_cancelled"
+    AuditAccountDeleted           = "account.deleted"
+)
+
+// anonymousEmailDomain is the domain of the anonymous account's email. The .invalid
+// top-level domain can never receive mail, and users can't sign up with it.
+const anonymousEmailDomain = "deleted.invalid"
+
+// for pending account deletions; the account is removed once ScheduledFor has passed
+type AccountDeletion struct {
+    UserID       int       `json:"-"`
+    Mode         string    `json:"mode"`
+    RequestedAt  time.Time `json:"requested_at"`
+    ScheduledFor time.Time `json:"scheduled_for"`
+}
+
+var ErrAccountDeletionNotFound = errors.New("no pending account deletion")
+
+// RequestAccountDeletion schedules the user's account for deletion, replacing any earlier request
+func RequestAccountDeletion(db *sql.DB, deletion *AccountDeletion) error {
+    query := `INSERT INTO account_deletions (user_id, mode, requested_at, scheduled_for) VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE mode = VALUES(mode), requested_at = VALUES(requested_at), scheduled_for = VALUES(scheduled_for)`
+    _, err := db.Exec(query, deletion.UserID, deletion.Mode, deletion.RequestedAt, deletion.ScheduledFor)
+    return err
+}
+
+// GetAccountDeletion returns the user's pending deletion request
+func GetAccountDeletion(db *sql.DB, userID int) (*AccountDeletion, error) {
+    var deletion AccountDeletion
+    err := db.QueryRow(`SELECT user_id, mode, requested_at, scheduled_for FROM account_deletions WHERE user_id = ?`, userID).
+        Scan(&deletion.UserID, &deletion.Mode, &deletion.RequestedAt, &deletion.ScheduledFor)
+    if err == sql.ErrNoRows {
+        return nil, ErrAccountDeletionNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &deletion, nil
+}
+
+// CancelAccountDeletion withdraws the user's pending deletion request
+func CancelAccountDeletion(db *sql.DB, userID int) error {
+    result, err := db.Exec(`DELETE FROM account_deletions WHERE user_id = ?`, userID)
+    if err != nil {
+        return err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if affected == 0 {
+        return ErrAccountDeletionNotFound
+    }
+    return nil
+}
+
+// GetDueAccountDeletions lists deletion requests whose grace period is over
+func GetDueAccountDeletions(db *sql.DB, now time.Time) ([]AccountDeletion, error) {
+    rows, err := db.Query(`SELECT user_id, mode, requested_at, scheduled_for FROM account_deletions WHERE scheduled_for <= ? ORDER BY scheduled_for`, now)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    deletions := []AccountDeletion{}
+    for rows.Next() {
+        var deletion AccountDeletion
+        if err := rows.Scan(&deletion.UserID, &deletion.Mode, &deletion.RequestedAt, &deletion.ScheduledFor); err != nil {
+            return nil, err
+        }
+        deletions = append(deletions, deletion)
+    }
+    return deletions, rows.Err()
+}
+
+// DeleteAccount removes a user and, depending on mode, deletes their posts or moves them
+// to the anonymous account. Tokens, sessions, passkeys and roles go with the users row
+// through their foreign keys. It returns the ids of the posts affected, so deleted
+// ones can be removed from the search index.
+func DeleteAccount(db *sql.DB, userID int, mode string) ([]int, error) {
+    if mode != DeletionModeAnonymize && mode != DeletionModeDelete {
+        return nil, fmt.Errorf("unknown deletion mode %q", mode)
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var email string
+    err = tx.QueryRow(`SELECT email FROM users WHERE id = ? FOR UPDATE`, userID).Scan(&email)
+    if err == sql.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    posts, err := lockAuthorPostIDs(tx, userID)
+    if err != nil {
+        return nil, err
+    }
+
+    switch mode {
+    case DeletionModeAnonymize:
+        anonymousID, err := anonymousUserID(tx)
+        if err != nil {
+            return nil, err
+        }
+        _, err = tx.Exec(`UPDATE blogs SET author_id = ?, name = 'Anonymous', username = NULL WHERE author_id = ?`, anonymousID, userID)
+        if err != nil {
+            return nil, err
+        }
+    case DeletionModeDelete:
+        _, err = tx.Exec(`DELETE FROM blogs WHERE author_id = ?`, userID)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // Login history is keyed by email rather than user id, so it isn't removed by the cascade
+    _, err = tx.Exec(`DELETE FROM login_attempts WHERE email = ?`, strings.ToLower(strings.TrimSpace(email)))
+    if err != nil {
+        return nil, err
+    }
+    _, err = tx.Exec(`DELETE FROM account_deletions WHERE user_id = ?`, userID)
+    if err != nil {
+        return nil, err
+    }
+    _, err = tx.Exec(`DELETE FROM users WHERE id = ?`, userID)
+    if err != nil {
+        return nil, err
+    }
+
+    return posts, tx.Commit()
+}
+
+// lockAuthorPostIDs lists the ids of a user's posts, locking them until tx ends
+func lockAuthorPostIDs(tx *sql.Tx, userID int) ([]int, error) {
+    rows, err := tx.Query(`SELECT id FROM blogs WHERE author_id = ? FOR UPDATE`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    ids := []int{}
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// anonymousUserID returns the id of the anonymous account, creating it on first use.
+// It is found by its is_anonymous flag, never by email; it has no usable password and
+// its email can't receive mail, so nobody can log in as it.
+func anonymousUserID(tx *sql.Tx) (int, error) {
+    var id int
+    err := tx.QueryRow(`SELECT id FROM users WHERE is_anonymous = TRUE`).Scan(&id)
+    if err != sql.ErrNoRows {
+        return id, err
+    }
+
+    // Pick a free username and email; a real user may already be called "anonymous"
+    username := "anonymous"
+    for i := 2; ; i++ {
+        var exists int
+        err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ? OR email = ?`, username, username+"@"+anonymousEmailDomain).Scan(&exists)
+        if err != nil {
+            return 0, err
+        }
+        if exists == 0 {
+            break
+        }
+        username = fmt.Sprintf("anonymous-%d", i)
+    }
+
+    result, err := tx.Exec(`INSERT INTO users (username, name, email, password, is_anonymous) VALUES (?, 'Anonymous', ?, '!', TRUE)`,
+        username, username+"@"+anonymousEmailDomain)
+    if err != nil {
+        return 0, err
+    }
+    newID, err := result.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+
+    // An explicit role keeps SeedRoles from making it an author
+    _, err = tx.Exec(`INSERT INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE name = ?`, newID, RoleReader)
+    return int(newID), err
+}
+
+// IsReservedEmail reports whether an email address may not be used for an account.
+// Addresses in the .invalid top-level domain, such as the anonymous account's, can
+// never receive mail.
+func IsReservedEmail(email string) bool {
+    email = strings.ToLower(strings.TrimSpace(email))
+    _, domain, _ := strings.Cut(email, "@")
+    domain = strings.TrimSuffix(domain, ".")
+    return domain == "invalid" || strings.HasSuffix(domain, ".invalid")
+}
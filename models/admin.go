@@ -0,0 +1,84 @@
+package models
+
+import (
+    "database/sql"
+    "strings"
+    "time"
+)
+
+// Audit log actions for admin user management
+const (
+    AuditUserSuspended   = "user.suspended"
+    AuditUserUnsuspended = "user.unsuspended"
+    AuditUserLoggedOut   = "user.force_logout"
+    AuditUserRemoved     = "user.removed"
+)
+
+// IsSuspended reports whether the account is suspended at now. A suspension without
+// an end date lasts until an admin lifts it.
+func (user *User) IsSuspended(now time.Time) bool {
+    if user.SuspendedAt == nil {
+        return false
+    }
+    return user.SuspendedUntil == nil || now.Before(*user.SuspendedUntil)
+}
+
+// SearchUsers pages through users, newest first. query matches the start of the
+// username, name or email; an empty query matches everyone.
+func SearchUsers(db *sql.DB, query string, limit, offset int) ([]User, int, error) {
+    where := ""
+    var args []interface{}
+    if query != "" {
+        pattern := escapeLike(query) + "%"
+        where = ` WHERE username LIKE ? OR name LIKE ? OR email LIKE ?`
+        args = append(args, pattern, pattern, pattern)
+    }
+
+    var total int
+    err := db.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&total)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := db.Query(`SELECT `+userColumns+` FROM users`+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+        append(args, limit, offset)...)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    users := []User{}
+    for rows.Next() {
+        user, err := scanUser(rows)
+        if err != nil {
+            return nil, 0, err
+        }
+        users = append(users, *user)
+    }
+    return users, total, rows.Err()
+}
+
+// escapeLike makes user input match literally inside a LIKE pattern
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SuspendUser blocks the user from logging in until until, or indefinitely if until is nil
+func SuspendUser(db *sql.DB, userID int, reason string, until *time.Time, suspendedBy int) error {
+    _, err := db.Exec(`UPDATE users SET suspended_at = ?, suspended_until = ?, suspension_reason = ?, suspended_by = ? WHERE id = ?`,
+        time.Now(), until, reason, suspendedBy, userID)
+    return err
+}
+
+// UnsuspendUser lifts a suspension
+func UnsuspendUser(db *sql.DB, userID int) error {
+    _, err := db.Exec(`UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL WHERE id = ?`, userID)
+    return err
+}
+
+// CountPostsByAuthor returns how many posts the user has written
+func CountPostsByAuthor(db *sql.DB, authorID int) (int, error) {
+    var count int
+    err := db.QueryRow(`SELECT COUNT(*) FROM blogs WHERE author_id = ?`, authorID).Scan(&count)
+    return count, err
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+    "database/sql"
+    "time"
+)
+
+// Actions recorded in the audit log
+const (
+    AuditAccountLocked  = "account.locked"
+    AuditIPLocked       = "ip.locked"
+    AuditLockoutCleared = "lockout.cleared"
+)
+
+// for security-relevant events; ActorUserID is nil for actions taken by the system
+type AuditEntry struct {
+    ID           int       `json:"id"`
+    ActorUserID  *int      `json:"actor_user_id"`
+    Action       string    `json:"action"`
+    TargetUserID *int      `json:"target_user_id"`
+    IP           string    `json:"ip"`
+    Details      string    `json:"details"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// RecordAudit appends an entry to the audit log
+func RecordAudit(db *sql.DB, entry *AuditEntry) error {
+    if entry.CreatedAt.IsZero() {
+        entry.CreatedAt = time.Now()
+    }
+    query := `INSERT INTO audit_log (actor_user_id, action, target_user_id, ip, details, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+    result, err := db.Exec(query, entry.ActorUserID, entry.Action, entry.TargetUserID, entry.IP, entry.Details, entry.CreatedAt)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    entry.ID = int(id)
+    return nil
+}
+
+// GetAuditEntriesForUser lists the audit log entries about a user, oldest first
+func GetAuditEntriesForUser(db *sql.DB, userID int) ([]AuditEntry, error) {
+    rows, err := db.Query(`SELECT id, actor_user_id, action, target_user_id, ip, details, created_at FROM audit_log
+        WHERE target_user_id = ? ORDER BY created_at, id`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := []AuditEntry{}
+    for rows.Next() {
+        var entry AuditEntry
+        var actor, target sql.NullInt64
+        var details sql.NullString
+        err := rows.Scan(&entry.ID, &actor, &entry.Action, &target, &entry.IP, &details, &entry.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+        if actor.Valid {
+            id := int(actor.Int64)
+            entry.ActorUserID = &id
+        }
+        if target.Valid {
+            id := int(target.Int64)
+            entry.TargetUserID = &id
+        }
+        entry.Details = details.String
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// Lockout scopes: a single account, or every login from one address
+const (
+    LockoutScopeEmail = "email"
+    LockoutScopeIP    = "ip"
+)
+
+// for temporary login lockouts; Key is the email or IP address depending on Scope
+type Lockout struct {
+    ID          int        `json:"id"`
+    Scope       string     `json:"scope"`
+    Key         string     `json:"key"`
+    LockedUntil time.Time  `json:"locked_until"`
+    CreatedAt   time.Time  `json:"created_at"`
+    ClearedAt   *time.Time `json:"cleared_at,omitempty"`
+    ClearedBy   *int       `json:"cleared_by,omitempty"`
+}
+
+var ErrLockoutNotFound = errors.New("lockout not found")
+
+// lockoutColumns maps a scope to its login_attempts column; scopes are never taken from user input
+var lockoutColumns = map[string]string{
+    LockoutScopeEmail: "email",
+    LockoutScopeIP:    "ip",
+}
+
+// RecordLoginAttempt stores the outcome of a login attempt
+func RecordLoginAttempt(db *sql.DB, email, ip string, success bool, at time.Time) error {
+    _, err := db.Exec(`INSERT INTO login_attempts (email, ip, success, created_at) VALUES (?, ?, ?, ?)`, email, ip, success, at)
+    return err
+}
+
+// FailedLoginsSince counts failed attempts for an email or IP after since, and returns
+// the time of the latest one
+func FailedLoginsSince(db *sql.DB, scope, key string, since time.Time) (int, *time.Time, error) {
+    column, ok := lockoutColumns[scope]
+    if !ok {
+        return 0, nil, errors.New("unknown lockout scope " + scope)
+    }
+
+    var count int
+    var last sql.NullTime
+    query := `SELECT COUNT(*), MAX(created_at) FROM login_attempts WHERE ` + column + ` = ? AND success = FALSE AND created_at > ?`
+    err := db.QueryRow(query, key, since).Scan(&count, &last)
+    if err != nil {
+        return 0, nil, err
+    }
+    if !last.Valid {
+        return count, nil, nil
+    }
+    return count, &last.Time, nil
+}
+
+// LastSuccessfulLogin returns the time of the latest successful login for an email, or nil
+func LastSuccessfulLogin(db *sql.DB, email string) (*time.Time, error) {
+    var last sql.NullTime
+    err := db.QueryRow(`SELECT MAX(created_at) FROM login_attempts WHERE email = ? AND success = TRUE`, email).Scan(&last)
+    if err != nil || !last.Valid {
+        return nil, err
+    }
+    return &last.Time, nil
+}
+
+// GetActiveLockout returns the lockout in force for an email or IP, or nil
+func GetActiveLockout(db *sql.DB, scope, key string, now time.Time) (*Lockout, error) {
+    query := `SELECT id, scope, lock_key, locked_until, created_at, cleared_at, cleared_by FROM login_lockouts
+        WHERE scope = ? AND lock_key = ? AND locked_until > ? AND cleared_at IS NULL
+        ORDER BY locked_until DESC LIMIT 1`
+    lockout, err := scanLockout(db.QueryRow(query, scope, key, now))
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    return lockout, err
+}
+
+// LatestLockoutStart returns when the most recent lockout for an email or IP began, or nil.
+// Failures before that point have already been punished and aren't counted again.
+func LatestLockoutStart(db *sql.DB, scope, key string) (*time.Time, error) {
+    var last sql.NullTime
+    err := db.QueryRow(`SELECT MAX(created_at) FROM login_lockouts WHERE scope = ? AND lock_key = ?`, scope, key).Scan(&last)
+    if err != nil || !last.Valid {
+        return nil, err
+    }
+    return &last.Time, nil
+}
+
+// CreateLockout locks out an email or IP address
+func CreateLockout(db *sql.DB, lockout *Lockout) error {
+    query := `INSERT INTO login_lockouts (scope, lock_key, locked_until, created_at) VALUES (?, ?, ?, ?)`
+    result, err := db.Exec(query, lockout.Scope, lockout.Key, lockout.LockedUntil, lockout.CreatedAt)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    lockout.ID = int(id)
+    return nil
+}
+
+// GetActiveLockouts lists lockouts that are still in force
+func GetActiveLockouts(db *sql.DB, now time.Time) ([]Lockout, error) {
+    rows, err := db.Query(`SELECT id, scope, lock_key, locked_until, created_at, cleared_at, cleared_by FROM login_lockouts
+        WHERE locked_until > ? AND cleared_at IS NULL ORDER BY created_at DESC`, now)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    lockouts := []Lockout{}
+    for rows.Next() {
+        lockout, err := scanLockout(rows)
+        if err != nil {
+            return nil, err
+        }
+        lockouts = append(lockouts, *lockout)
+    }
+    return lockouts, rows.Err()
+}
+
+// ClearLockout lifts an active lockout before it expires
+func ClearLockout(db *sql.DB, id, clearedBy int, now time.Time) (*Lockout, error) {
+    lockout, err := scanLockout(db.QueryRow(`SELECT id, scope, lock_key, locked_until, created_at, cleared_at, cleared_by FROM login_lockouts
+        WHERE id = ? AND locked_until > ? AND cleared_at IS NULL`, id, now))
+    if err == sql.ErrNoRows {
+        return nil, ErrLockoutNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = db.Exec(`UPDATE login_lockouts SET cleared_at = ?, cleared_by = ? WHERE id = ?`, now, clearedBy, id)
+    if err != nil {
+        return nil, err
+    }
+    lockout.ClearedAt = &now
+    lockout.ClearedBy = &clearedBy
+    return lockout, nil
+}
+
+func scanLockout(row rowScanner) (*Lockout, error) {
+    var lockout Lockout
+    var clearedAt sql.NullTime
+    var clearedBy sql.NullInt64
+    err := row.Scan(&lockout.ID, &lockout.Scope, &lockout.Key, &lockout.LockedUntil, &lockout.CreatedAt, &clearedAt, &clearedBy)
+    if err != nil {
+        return nil, err
+    }
+    if clearedAt.Valid {
+        lockout.ClearedAt = &clearedAt.Time
+    }
+    if clearedBy.Valid {
+        by := int(clearedBy.Int64)
+        lockout.ClearedBy = &by
+    }
+    return &lockout, nil
+}
+
+// for login history in the personal data export
+type LoginAttempt struct {
+    IP        string    `json:"ip"`
+    Success   bool      `json:"success"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// GetLoginAttemptsByEmail lists the recorded logins for an email, newest first
+func GetLoginAttemptsByEmail(db *sql.DB, email string) ([]LoginAttempt, error) {
+    rows, err := db.Query(`SELECT ip, success, created_at FROM login_attempts WHERE email = ? ORDER BY created_at DESC`, email)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    attempts := []LoginAttempt{}
+    for rows.Next() {
+        var attempt LoginAttempt
+        if err := rows.Scan(&attempt.IP, &attempt.Success, &attempt.CreatedAt); err != nil {
+            return nil, err
+        }
+        attempts = append(attempts, attempt)
+    }
+    return attempts, rows.Err()
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+    "database/sql"
+    "time"
+)
+
+// GetUserByOIDCSubject finds the user provisioned for an identity provider subject
+func GetUserByOIDCSubject(db *sql.DB, issuer, subject string) (*User, error) {
+    row := db.QueryRow(`SELECT `+userColumns+` FROM users WHERE oidc_issuer = ? AND oidc_subject = ?`, issuer, subject)
+    return scanUser(row)
+}
+
+// LinkOIDCIdentity attaches an identity provider subject to an existing user
+func LinkOIDCIdentity(db *sql.DB, userID int, issuer, subject string) error {
+    _, err := db.Exec(`UPDATE users SET oidc_issuer = ?, oidc_subject = ? WHERE id = ?`, issuer, subject, userID)
+    return err
+}
+
+// CreateOIDCUser inserts a user who signs in only through the identity provider.
+// The password is not a valid bcrypt hash, so password login always fails for them.
+func CreateOIDCUser(db *sql.DB, user *User, issuer, subject string) error {
+    query := `INSERT INTO users (username, name, email, password, email_verified_at, oidc_issuer, oidc_subject) VALUES (?, ?, ?, ?, ?, ?, ?)`
+    result, err := db.Exec(query, user.Username, user.Name, user.Email, "!", user.EmailVerifiedAt, issuer, subject)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    user.ID = int(id)
+    user.CreatedAt = time.Now()
+    return AssignDefaultRole(db, user.ID)
+}
+
+// UsernameExists reports whether a username is taken
+func UsernameExists(db *sql.DB, username string) (bool, error) {
+    var exists int
+    err := db.QueryRow(`SELECT 1 FROM users WHERE username = ? LIMIT 1`, username).Scan(&exists)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return err == nil, err
+}
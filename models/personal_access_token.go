@@ -0,0 +1,128 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "strings"
+    "time"
+)
+
+// Scopes a personal access token can be granted
+const (
+    ScopePostsWrite   = "posts:write"
+    ScopeProfileRead  = "profile:read"
+    ScopeProfileWrite = "profile:write"
+)
+
+var PersonalAccessTokenScopes = []string{ScopePostsWrite, ScopeProfileRead, ScopeProfileWrite}
+
+// for personal access tokens used by scripts and CI; only the SHA-256 is stored
+type PersonalAccessToken struct {
+    ID          int        `json:"id"`
+    UserID      int        `json:"-"`
+    Name        string     `json:"name"`
+    TokenPrefix string     `json:"token_prefix"`
+    TokenHash   string     `json:"-"`
+    Scopes      []string   `json:"scopes"`
+    LastUsedAt  *time.Time `json:"last_used_at"`
+    ExpiresAt   *time.Time `json:"expires_at"`
+    RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+    CreatedAt   time.Time  `json:"created_at"`
+}
+
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+
+const personalAccessTokenColumns = `id, user_id, name, token_prefix, token_hash, scopes, last_used_at, expires_at, revoked_at, created_at`
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+    var token PersonalAccessToken
+    var scopes string
+    err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenPrefix, &token.TokenHash, &scopes,
+        &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt, &token.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrPersonalAccessTokenNotFound
+        }
+        return nil, err
+    }
+    token.Scopes = strings.Fields(scopes)
+    return &token, nil
+}
+
+// HasScope reports whether the token was granted scope
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+    for _, s := range t.Scopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// CreatePersonalAccessToken stores a new personal access token
+func CreatePersonalAccessToken(db *sql.DB, token *PersonalAccessToken) error {
+    query := `INSERT INTO personal_access_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)`
+    result, err := db.Exec(query, token.UserID, token.Name, token.TokenPrefix, token.TokenHash, strings.Join(token.Scopes, " "), token.ExpiresAt)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    token.ID = int(id)
+    token.CreatedAt = time.Now()
+    return nil
+}
+
+// GetPersonalAccessTokensByUser lists a user's tokens, newest first, including revoked ones
+func GetPersonalAccessTokensByUser(db *sql.DB, userID int) ([]PersonalAccessToken, error) {
+    rows, err := db.Query(`SELECT `+personalAccessTokenColumns+` FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    tokens := []PersonalAccessToken{}
+    for rows.Next() {
+        token, err := scanPersonalAccessToken(rows)
+        if err != nil {
+            return nil, err
+        }
+        tokens = append(tokens, *token)
+    }
+    return tokens, rows.Err()
+}
+
+// GetActivePersonalAccessTokenByHash finds a token that is neither revoked nor expired
+func GetActivePersonalAccessTokenByHash(db *sql.DB, tokenHash string) (*PersonalAccessToken, error) {
+    query := `SELECT ` + personalAccessTokenColumns + ` FROM personal_access_tokens
+        WHERE token_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`
+    return scanPersonalAccessToken(db.QueryRow(query, tokenHash, time.Now()))
+}
+
+// TouchPersonalAccessToken records that a token was used. To avoid a write on every
+// request the timestamp is only updated once a minute.
+func TouchPersonalAccessToken(db *sql.DB, id int) error {
+    now := time.Now()
+    _, err := db.Exec(`UPDATE personal_access_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+        now, id, now.Add(-time.Minute))
+    return err
+}
+
+// RevokePersonalAccessToken revokes one of the user's personal access tokens
+func RevokePersonalAccessToken(db *sql.DB, userID, id int) error {
+    result, err := db.Exec(`UPDATE personal_access_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, time.Now(), id, userID)
+    if err != nil {
+        return err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if affected == 0 {
+        return ErrPersonalAccessTokenNotFound
+    }
+    return nil
+}
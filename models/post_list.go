@@ -0,0 +1,190 @@
+package models
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+const (
+    DefaultPostPageSize = 20
+    MaxPostPageSize     = 100
+)
+
+const (
+    PostSortCreatedAt = "created_at"
+    PostSortUpdatedAt = "updated_at"
+    PostSortTitle     = "title"
+)
+
+// postSortColumns whitelists the columns posts can be sorted by. Sort fields from the
+// request are only ever used as keys into this map, never put into SQL directly.
+var postSortColumns = map[string]string{
+    PostSortCreatedAt: "b.created_at",
+    PostSortUpdatedAt: "b.updated_at",
+    PostSortTitle:     "b.title",
+}
+
+var (
+    ErrInvalidCursor   = errors.New("invalid cursor")
+    ErrInvalidPostSort = errors.New("invalid sort field")
+)
+
+// PostFilter narrows and orders a post listing. Zero values mean no restriction,
+// and posts are sorted newest first by default.
+type PostFilter struct {
+    AuthorID      *int
+    Status        string
+    CreatedAfter  *time.Time // inclusive
+    CreatedBefore *time.Time // exclusive
+    UpdatedAfter  *time.Time // inclusive
+    UpdatedBefore *time.Time // exclusive
+    TitlePrefix   string
+    Sort          string
+    Ascending     bool
+}
+
+func IsPostSortField(field string) bool {
+    _, ok := postSortColumns[field]
+    return ok
+}
+
+// PostCursor marks the last post of a page by its sort value, with the id breaking
+// ties. It records the ordering it was made for, so it can't be reused with another.
+type PostCursor struct {
+    Sort      string `json:"s"`
+    Ascending bool   `json:"a,omitempty"`
+    Value     string `json:"v"`
+    ID        int    `json:"i"`
+}
+
+// Encode turns the cursor into the opaque string clients pass back as ?after=
+func (cursor *PostCursor) Encode() string {
+    data, _ := json.Marshal(cursor)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodePostCursor(value string) (*PostCursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(value)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    var cursor PostCursor
+    err = json.Unmarshal(data, &cursor)
+    if err != nil || cursor.ID <= 0 || !IsPostSortField(cursor.Sort) {
+        return nil, ErrInvalidCursor
+    }
+    return &cursor, nil
+}
+
+// sortValue is the value of the sort field the cursor stores for a post
+func (filter *PostFilter) sortValue(post *Post) string {
+    switch filter.Sort {
+    case PostSortUpdatedAt:
+        return post.UpdatedAt.Format(time.RFC3339Nano)
+    case PostSortTitle:
+        return post.Title
+    default:
+        return post.CreatedAt.Format(time.RFC3339Nano)
+    }
+}
+
+// cursorArg converts the cursor's value back to a query argument for the sort field
+func (filter *PostFilter) cursorArg(cursor *PostCursor) (interface{}, error) {
+    if cursor.Sort != filter.Sort || cursor.Ascending != filter.Ascending {
+        return nil, ErrInvalidCursor
+    }
+    if filter.Sort == PostSortTitle {
+        return cursor.Value, nil
+    }
+    value, err := time.Parse(time.RFC3339Nano, cursor.Value)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+    return value, nil
+}
+
+// ListPosts returns up to limit posts matching the filter after the cursor (from the
+// start when it is nil), and the cursor for the next page, which is nil on the last page
+func ListPosts(db *sql.DB, filter PostFilter, after *PostCursor, limit int) ([]Post, *PostCursor, error) {
+    if filter.Sort == "" {
+        filter.Sort = PostSortCreatedAt
+    }
+    column, ok := postSortColumns[filter.Sort]
+    if !ok {
+        return nil, nil, ErrInvalidPostSort
+    }
+    if limit <= 0 || limit > MaxPostPageSize {
+        limit = MaxPostPageSize
+    }
+
+    where := ""
+    args := []interface{}{}
+    add := func(condition string, values ...interface{}) {
+        if where == "" {
+            where = " WHERE "
+        } else {
+            where += " AND "
+        }
+        where += condition
+        args = append(args, values...)
+    }
+
+    if filter.AuthorID != nil {
+        add("b.author_id = ?", *filter.AuthorID)
+    }
+    if filter.Status != "" {
+        add("b.status = ?", filter.Status)
+    }
+    if filter.CreatedAfter != nil {
+        add("b.created_at >= ?", *filter.CreatedAfter)
+    }
+    if filter.CreatedBefore != nil {
+        add("b.created_at < ?", *filter.CreatedBefore)
+    }
+    if filter.UpdatedAfter != nil {
+        add("b.updated_at >= ?", *filter.UpdatedAfter)
+    }
+    if filter.UpdatedBefore != nil {
+        add("b.updated_at < ?", *filter.UpdatedBefore)
+    }
+    if filter.TitlePrefix != "" {
+        add("b.title LIKE ?", escapeLike(filter.TitlePrefix)+"%")
+    }
+
+    direction, compare := "DESC", "<"
+    if filter.Ascending {
+        direction, compare = "ASC", ">"
+    }
+    if after != nil {
+        value, err := filter.cursorArg(after)
+        if err != nil {
+            return nil, nil, err
+        }
+        add("("+column+" "+compare+" ? OR ("+column+" = ? AND b.id "+compare+" ?))", value, value, after.ID)
+    }
+
+    // One extra row tells us whether there is another page
+    query := `SELECT ` + postColumns + postsFrom + where +
+        ` ORDER BY ` + column + ` ` + direction + `, b.id ` + direction + ` LIMIT ?`
+    args = append(args, limit+1)
+
+    posts, err := queryPosts(db, query, args...)
+    if err != nil {
+        return nil, nil, err
+    }
+    if len(posts) <= limit {
+        return posts, nil, nil
+    }
+
+    posts = posts[:limit]
+    last := &posts[limit-1]
+    return posts, &PostCursor{
+        Sort:      filter.Sort,
+        Ascending: filter.Ascending,
+        Value:     filter.sortValue(last),
+        ID:        last.ID,
+    }, nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+var ErrPostRevisionNotFound = errors.New("post revision not found")
+
+// PostRevision is a saved version of a post's title and content. Revisions are
+// numbered from 1 for each post; the highest number is the current version.
+type PostRevision struct {
+    ID        int       `json:"-"`
+    PostID    int       `json:"post_id"`
+    Revision  int       `json:"revision"`
+    Title     string    `json:"title"`
+    Content   string    `json:"content,omitempty"`
+    AuthorID  *int      `json:"author_id"`
+    Username  string    `json:"username"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// savePostRevision stores the post's title and content as its next revision, unless
+// they are unchanged since the latest one. The post's row must be locked by tx.
+func savePostRevision(tx *sql.Tx, post *Post, authorID *int, at time.Time) error {
+    var latest int
+    var title, content sql.NullString
+    err := tx.QueryRow(`SELECT r.revision, r.title, r.content FROM post_revisions r
+        WHERE r.post_id = ? ORDER BY r.revision DESC LIMIT 1`, post.ID).Scan(&latest, &title, &content)
+    if err != nil && err != sql.ErrNoRows {
+        return err
+    }
+    if latest > 0 && title.String == post.Title && content.String == post.Content {
+        return nil
+    }
+
+    _, err = tx.Exec(`INSERT INTO post_revisions (post_id, revision, title, content, author_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+        post.ID, latest+1, post.Title, post.Content, authorID, at)
+    return err
+}
+
+// saveBaselineRevision records the post as it is before its first tracked change.
+// Posts written before revisions existed have none yet; their baseline is credited
+// to the post's author at the time of its last update.
+func saveBaselineRevision(tx *sql.Tx, postID int) error {
+    var count int
+    err := tx.QueryRow(`SELECT COUNT(*) FROM post_revisions WHERE post_id = ?`, postID).Scan(&count)
+    if err != nil || count > 0 {
+        return err
+    }
+
+    _, err = tx.Exec(`INSERT INTO post_revisions (post_id, revision, title, content, author_id, created_at)
+        SELECT id, 1, title, content, author_id, updated_at FROM blogs WHERE id = ?`, postID)
+    return err
+}
+
+// GetPostRevisions lists a post's revisions, newest first, without their content
+func GetPostRevisions(db *sql.DB, postID int) ([]PostRevision, error) {
+    rows, err := db.Query(`SELECT r.id, r.post_id, r.revision, r.title, r.author_id, COALESCE(u.username, ''), r.created_at
+        FROM post_revisions r LEFT JOIN users u ON u.id = r.author_id
+        WHERE r.post_id = ? ORDER BY r.revision DESC`, postID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    revisions := []PostRevision{}
+    for rows.Next() {
+        var revision PostRevision
+        var authorID sql.NullInt64
+        err := rows.Scan(&revision.ID, &revision.PostID, &revision.Revision, &revision.Title, &authorID, &revision.Username, &revision.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+        if authorID.Valid {
+            id := int(authorID.Int64)
+            revision.AuthorID = &id
+        }
+        revisions = append(revisions, revision)
+    }
+    return revisions, rows.Err()
+}
+
+// GetPostRevision retrieves one revision of a post, with its content
+func GetPostRevision(db *sql.DB, postID, number int) (*PostRevision, error) {
+    var revision PostRevision
+    var authorID sql.NullInt64
+    err := db.QueryRow(`SELECT r.id, r.post_id, r.revision, r.title, r.content, r.author_id, COALESCE(u.username, ''), r.created_at
+        FROM post_revisions r LEFT JOIN users u ON u.id = r.author_id
+        WHERE r.post_id = ? AND r.revision = ?`, postID, number).
+        Scan(&revision.ID, &revision.PostID, &revision.Revision, &revision.Title, &revision.Content, &authorID, &revision.Username, &revision.CreatedAt)
+    if err == sql.ErrNoRows {
+        return nil, ErrPostRevisionNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if authorID.Valid {
+        id := int(authorID.Int64)
+        revision.AuthorID = &id
+    }
+    return &revision, nil
+}
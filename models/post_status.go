@@ -0,0 +1,63 @@
+package models
+
+import (
+    "database/sql"
+    "strings"
+    "time"
+)
+
+// Post statuses. Only published posts are shown publicly; scheduled posts are
+// published by a background job once their publish_at time has passed.
+const (
+    PostStatusDraft     = "draft"
+    PostStatusScheduled = "scheduled"
+    PostStatusPublished = "published"
+    PostStatusArchived  = "archived"
+)
+
+func IsValidPostStatus(status string) bool {
+    switch status {
+    case PostStatusDraft, PostStatusScheduled, PostStatusPublished, PostStatusArchived:
+        return true
+    }
+    return false
+}
+
+// IsPublished reports whether the post is visible to everyone
+func (post *Post) IsPublished() bool {
+    return post.Status == PostStatusPublished
+}
+
+// PublishDuePosts publishes scheduled posts whose publish_at is at or before now
+// and returns their ids
+func PublishDuePosts(db *sql.DB, now time.Time) ([]int, error) {
+    rows, err := db.Query(`SELECT id FROM blogs WHERE status = ? AND publish_at <= ?`, PostStatusScheduled, now)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int
+    args := []interface{}{PostStatusPublished, PostStatusScheduled}
+    for rows.Next() {
+        var id int
+        err := rows.Scan(&id)
+        if err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+        args = append(args, id)
+    }
+    err = rows.Err()
+    if err != nil || len(ids) == 0 {
+        return nil, err
+    }
+
+    // Checking the status again skips posts that were unscheduled in the meantime
+    placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+    _, err = db.Exec(`UPDATE blogs SET status = ? WHERE status = ? AND id IN (`+placeholders+`)`, args...)
+    if err != nil {
+        return nil, err
+    }
+    return ids, nil
+}
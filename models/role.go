@@ -0,0 +1,186 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "strings"
+)
+
+// Built-in roles
+const (
+    RoleAdmin  = "admin"
+    RoleEditor = "editor"
+    RoleAuthor = "author"
+    RoleReader = "reader"
+)
+
+// DefaultRole is given to every new account
+const DefaultRole = RoleAuthor
+
+// Permissions checked by the API
+const (
+    PermissionPostsCreate    = "posts:create"
+    PermissionPostsUpdateOwn = "posts:update:own"
+    PermissionPostsUpdateAny = "posts:update:any"
+    PermissionPostsDeleteOwn = "posts:delete:own"
+    PermissionPostsDeleteAny = "posts:delete:any"
+    PermissionRolesAssign    = "roles:assign"
+    PermissionUsersManage    = "users:manage"
+)
+
+// DefaultRolePermissions is seeded into the database at startup
+var DefaultRolePermissions = map[string][]string{
+    RoleReader: {},
+    RoleAuthor: {PermissionPostsCreate, PermissionPostsUpdateOwn, PermissionPostsDeleteOwn},
+    RoleEditor: {PermissionPostsCreate, PermissionPostsUpdateOwn, PermissionPostsDeleteOwn, PermissionPostsUpdateAny, PermissionPostsDeleteAny},
+    RoleAdmin: {PermissionPostsCreate, PermissionPostsUpdateOwn, PermissionPostsDeleteOwn, PermissionPostsUpdateAny, PermissionPostsDeleteAny,
+        PermissionRolesAssign, PermissionUsersManage},
+}
+
+// for roles, with the permissions they grant
+type Role struct {
+    ID          int      `json:"id"`
+    Name        string   `json:"name"`
+    Permissions []string `json:"permissions"`
+}
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// SeedRoles creates the built-in roles and permissions. Existing grants are left alone,
+// so permissions added to a role by hand survive a restart.
+func SeedRoles(db *sql.DB) error {
+    for role, permissions := range DefaultRolePermissions {
+        _, err := db.Exec(`INSERT IGNORE INTO roles (name) VALUES (?)`, role)
+        if err != nil {
+            return err
+        }
+        for _, permission := range permissions {
+            _, err = db.Exec(`INSERT IGNORE INTO permissions (name) VALUES (?)`, permission)
+            if err != nil {
+                return err
+            }
+            _, err = db.Exec(`INSERT IGNORE INTO role_permissions (role_id, permission_id)
+                SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = ? AND p.name = ?`, role, permission)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    // Accounts from before roles existed keep the access they had
+    _, err := db.Exec(`INSERT IGNORE INTO user_roles (user_id, role_id)
+        SELECT u.id, r.id FROM users u, roles r
+        WHERE r.name = ? AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id)`, DefaultRole)
+    return err
+}
+
+// GetRoles lists all roles with their permissions
+func GetRoles(db *sql.DB) ([]Role, error) {
+    rows, err := db.Query(`SELECT r.id, r.name, COALESCE(GROUP_CONCAT(p.name ORDER BY p.name SEPARATOR ' '), '')
+        FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role_id = r.id
+        LEFT JOIN permissions p ON p.id = rp.permission_id
+        GROUP BY r.id, r.name
+        ORDER BY r.name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    roles := []Role{}
+    for rows.Next() {
+        var role Role
+        var permissions string
+        if err := rows.Scan(&role.ID, &role.Name, &permissions); err != nil {
+            return nil, err
+        }
+        role.Permissions = strings.Fields(permissions)
+        roles = append(roles, role)
+    }
+    return roles, rows.Err()
+}
+
+// GetUserRoles returns the names of the user's roles
+func GetUserRoles(db *sql.DB, userID int) ([]string, error) {
+    rows, err := db.Query(`SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = ? ORDER BY r.name`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    roles := []string{}
+    for rows.Next() {
+        var role string
+        if err := rows.Scan(&role); err != nil {
+            return nil, err
+        }
+        roles = append(roles, role)
+    }
+    return roles, rows.Err()
+}
+
+// GetUserPermissions returns every permission granted by any of the user's roles
+func GetUserPermissions(db *sql.DB, userID int) (map[string]bool, error) {
+    rows, err := db.Query(`SELECT DISTINCT p.name
+        FROM user_roles ur
+        JOIN role_permissions rp ON rp.role_id = ur.role_id
+        JOIN permissions p ON p.id = rp.permission_id
+        WHERE ur.user_id = ?`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    permissions := make(map[string]bool)
+    for rows.Next() {
+        var permission string
+        if err := rows.Scan(&permission); err != nil {
+            return nil, err
+        }
+        permissions[permission] = true
+    }
+    return permissions, rows.Err()
+}
+
+// AssignDefaultRole gives a newly created user DefaultRole
+func AssignDefaultRole(db *sql.DB, userID int) error {
+    _, err := db.Exec(`INSERT IGNORE INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE name = ?`, userID, DefaultRole)
+    return err
+}
+
+// SetUserRoles replaces the user's roles. assignedBy is the admin making the change.
+func SetUserRoles(db *sql.DB, userID int, roles []string, assignedBy int) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`DELETE FROM user_roles WHERE user_id = ?`, userID)
+    if err != nil {
+        return err
+    }
+    for _, role := range roles {
+        result, err := tx.Exec(`INSERT IGNORE INTO user_roles (user_id, role_id, assigned_by) SELECT ?, id, ? FROM roles WHERE name = ?`,
+            userID, assignedBy, role)
+        if err != nil {
+            return err
+        }
+        affected, err := result.RowsAffected()
+        if err != nil {
+            return err
+        }
+        if affected == 0 {
+            return ErrUnknownRole
+        }
+    }
+
+    return tx.Commit()
+}
+
+// GrantRoleByEmail gives the user with this email a role, if such a user exists
+func GrantRoleByEmail(db *sql.DB, email, role string) error {
+    _, err := db.Exec(`INSERT IGNORE INTO user_roles (user_id, role_id)
+        SELECT u.id, r.id FROM users u, roles r WHERE u.email = ? AND r.name = ?`, email, role)
+    return err
+}
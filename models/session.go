@@ -0,0 +1,110 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// for logins; a session's ID is the family_id of its refresh tokens
+type Session struct {
+    ID         string     `json:"id"`
+    UserID     int        `json:"-"`
+    UserAgent  string     `json:"user_agent"`
+    IP         string     `json:"ip"`
+    CreatedAt  time.Time  `json:"created_at"`
+    LastSeenAt time.Time  `json:"last_seen_at"`
+    RevokedAt  *time.Time `json:"-"`
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval limits how often last_seen_at is written for a busy session
+const sessionTouchInterval = time.Minute
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at`
+
+func scanSession(row rowScanner) (*Session, error) {
+    var session Session
+    var revokedAt sql.NullTime
+    err := row.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &revokedAt)
+    if err != nil {
+        return nil, err
+    }
+    if revokedAt.Valid {
+        session.RevokedAt = &revokedAt.Time
+    }
+    return &session, nil
+}
+
+// CreateSession stores a new login
+func CreateSession(db *sql.DB, session *Session) error {
+    query := `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?)`
+    _, err := db.Exec(query, session.ID, session.UserID, session.UserAgent, session.IP, session.CreatedAt, session.LastSeenAt)
+    return err
+}
+
+// GetSession looks up a session, including revoked ones
+func GetSession(db *sql.DB, id string) (*Session, error) {
+    session, err := scanSession(db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+    if err == sql.ErrNoRows {
+        return nil, ErrSessionNotFound
+    }
+    return session, err
+}
+
+// GetActiveSessionsByUser lists the user's sessions that haven't been revoked and
+// can still be refreshed, most recently used first
+func GetActiveSessionsByUser(db *sql.DB, userID int) ([]Session, error) {
+    rows, err := db.Query(`SELECT `+sessionColumns+` FROM sessions s
+        WHERE s.user_id = ? AND s.revoked_at IS NULL
+        AND EXISTS (SELECT 1 FROM refresh_tokens rt WHERE rt.family_id = s.id AND rt.revoked_at IS NULL AND rt.expires_at > ?)
+        ORDER BY s.last_seen_at DESC`, userID, time.Now())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    sessions := []Session{}
+    for rows.Next() {
+        session, err := scanSession(rows)
+        if err != nil {
+            return nil, err
+        }
+        sessions = append(sessions, *session)
+    }
+    return sessions, rows.Err()
+}
+
+// TouchSession records activity on a session, at most once per sessionTouchInterval
+func TouchSession(db *sql.DB, id string, now time.Time) error {
+    _, err := db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ? AND last_seen_at < ?`, now, id, now.Add(-sessionTouchInterval))
+    return err
+}
+
+// RevokeSession ends one of the user's sessions, including its refresh tokens
+func RevokeSession(db *sql.DB, userID int, id string) error {
+    session, err := GetSession(db, id)
+    if err != nil {
+        return err
+    }
+    if session.UserID != userID || session.RevokedAt != nil {
+        return ErrSessionNotFound
+    }
+    return RevokeRefreshTokenFamily(db, id)
+}
+
+// RevokeUserSessions ends all of the user's sessions except keepID, which may be empty
+func RevokeUserSessions(db *sql.DB, userID int, keepID string) error {
+    if keepID == "" {
+        return RevokeUserRefreshTokens(db, userID)
+    }
+
+    now := time.Now()
+    _, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND family_id <> ? AND revoked_at IS NULL`, now, userID, keepID)
+    if err != nil {
+        return err
+    }
+    _, err = db.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL`, now, userID, keepID)
+    return err
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// for refresh tokens; only the SHA-256 of the token is stored
+type RefreshToken struct {
+    ID        int
+    UserID    int
+    FamilyID  string
+    TokenHash string
+    ExpiresAt time.Time
+    RevokedAt sql.NullTime
+    CreatedAt time.Time
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// CreateRefreshToken stores a new refresh token
+func CreateRefreshToken(db *sql.DB, token *RefreshToken) error {
+    query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES (?, ?, ?, ?)`
+    result, err := db.Exec(query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    token.ID = int(id)
+    return nil
+}
+
+// GetRefreshTokenByHash looks up a refresh token, including revoked and expired ones
+func GetRefreshTokenByHash(db *sql.DB, tokenHash string) (*RefreshToken, error) {
+    var token RefreshToken
+    query := `SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = ?`
+    err := db.QueryRow(query, tokenHash).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.RevokedAt, &token.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrRefreshTokenNotFound
+        }
+        return nil, err
+    }
+    return &token, nil
+}
+
+// RotateRefreshToken revokes old and stores next in one transaction. It returns
+// ErrRefreshTokenNotFound if old was already revoked by a concurrent request.
+func RotateRefreshToken(db *sql.DB, old *RefreshToken, next *RefreshToken) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), old.ID)
+    if err != nil {
+        return err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if affected == 0 {
+        return ErrRefreshTokenNotFound
+    }
+
+    query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES (?, ?, ?, ?)`
+    result, err = tx.Exec(query, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt)
+    if err != nil {
+        return err
+    }
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    next.ID = int(id)
+
+    return tx.Commit()
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login,
+// and the session of that login with it
+func RevokeRefreshTokenFamily(db *sql.DB, familyID string) error {
+    now := time.Now()
+    _, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`, now, familyID)
+    if err != nil {
+        return err
+    }
+    _, err = db.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, now, familyID)
+    return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token and session belonging to a user
+func RevokeUserRefreshTokens(db *sql.DB, userID int) error {
+    now := time.Now()
+    _, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID)
+    if err != nil {
+        return err
+    }
+    _, err = db.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID)
+    return err
+}
+
+// RevokeAccessToken denylists an access token by its jti until it would have expired anyway
+func RevokeAccessToken(db *sql.DB, jti string, expiresAt time.Time) error {
+    _, err := db.Exec(`INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`, jti, expiresAt)
+    if err != nil {
+        return err
+    }
+
+    // Rows past their expiry can never match a valid token again
+    _, err = db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now())
+    return err
+}
+
+// IsAccessTokenRevoked reports whether the jti has been denylisted
+func IsAccessTokenRevoked(db *sql.DB, jti string) (bool, error) {
+    var exists int
+    err := db.QueryRow(`SELECT 1 FROM revoked_tokens WHERE jti = ?`, jti).Scan(&exists)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    return true, nil
+}
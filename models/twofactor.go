@@ -0,0 +1,86 @@
+package models
+
+import (
+    "database/sql"
+    "time"
+)
+
+// SetTOTPSecret stores a new, not yet enabled, TOTP secret for the user
+func SetTOTPSecret(db *sql.DB, userID int, secret string) error {
+    _, err := db.Exec(`UPDATE users SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?`, secret, userID)
+    return err
+}
+
+// EnableTOTP turns on two-factor login and replaces the user's recovery codes
+func EnableTOTP(db *sql.DB, userID int, step int64, recoveryCodeHashes []string) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?`, time.Now(), step, userID)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID)
+    if err != nil {
+        return err
+    }
+    for _, hash := range recoveryCodeHashes {
+        _, err = tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash)
+        if err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+// DisableTOTP removes the secret and recovery codes
+func DisableTOTP(db *sql.DB, userID int) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?`, userID)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false if that
+// step (or a later one) was already used, i.e. the code is being replayed.
+func UseTOTPStep(db *sql.DB, userID int, step int64) (bool, error) {
+    result, err := db.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)`, step, userID, step)
+    if err != nil {
+        return false, err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return affected == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used; it returns false if there is no such code
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+    result, err := db.Exec(`UPDATE totp_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, time.Now(), userID, codeHash)
+    if err != nil {
+        return false, err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return affected == 1, nil
+}
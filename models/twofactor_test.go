@@ -0,0 +1,58 @@
+package models
+
+import (
+    "database/sql"
+    "os"
+    "testing"
+    _ "github.com/go-sql-driver/mysql"
+)
+
+// testDB connects to the MySQL database in TEST_MYSQL_DSN, skipping the test when it
+// isn't set. A single connection is used so tests can shadow tables with temporary ones.
+func testDB(t *testing.T) *sql.DB {
+    dsn := os.Getenv("TEST_MYSQL_DSN")
+    if dsn == "" {
+        t.Skip("TEST_MYSQL_DSN is not set")
+    }
+    db, err := sql.Open("mysql", dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    db.SetMaxOpenConns(1)
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+func TestUseTOTPStepRejectsReplays(t *testing.T) {
+    db := testDB(t)
+    _, err := db.Exec(`CREATE TEMPORARY TABLE users (id INT PRIMARY KEY, totp_last_step BIGINT NULL)`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = db.Exec(`INSERT INTO users (id) VALUES (1), (2)`)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    steps := []struct {
+        name   string
+        userID int
+        step   int64
+        want   bool
+    }{
+        {"first code", 1, 100, true},
+        {"same code again", 1, 100, false},
+        {"earlier code in the window", 1, 99, false},
+        {"next code", 1, 101, true},
+        {"other users are separate", 2, 100, true},
+    }
+    for _, tt := range steps {
+        ok, err := UseTOTPStep(db, tt.userID, tt.step)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ok != tt.want {
+            t.Fatalf("%s: UseTOTPStep(%d, %d) = %t, want %t", tt.name, tt.userID, tt.step, ok, tt.want)
+        }
+    }
+}
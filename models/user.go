@@ -3,8 +3,10 @@ package models
 import (
     "database/sql"
     "errors"
+    "strings"
     "time"
     "fmt"
+    "github.com/go-sql-driver/mysql"
 )
 
 // for User
@@ -15,17 +17,28 @@ type User struct {
     Email     string    `json:"email"`
     Password  string    `json:"password"`
     CreatedAt time.Time `json:"created_at"`
+    EmailVerifiedAt *time.Time `json:"email_verified_at"`
+    TOTPSecret      sql.NullString `json:"-"`
+    TOTPEnabledAt   *time.Time `json:"-"`
+    PasswordChangedAt *time.Time `json:"-"`
+    Bio             string     `json:"bio"`
+    SuspendedAt      *time.Time     `json:"-"`
+    SuspendedUntil   *time.Time     `json:"-"`
+    SuspensionReason sql.NullString `json:"-"`
 }
 
 // for blog post
 type Post struct {
-    ID         int       `json:"id"`
-    Name       string    `json:"name"`
-    Title      string    `json:"title"`
-    Content    string    `json:"content"`
-    Username   string    `json:"username"`
-    CreatedAt  time.Time `json:"created_at"`
-    UpdatedAt  time.Time `json:"updated_at"`
+    ID         int        `json:"id"`
+    Name       string     `json:"name"`
+    Title      string     `json:"title"`
+    Content    string     `json:"content"`
+    AuthorID   *int       `json:"author_id"`
+    Username   string     `json:"username"`
+    Status     string     `json:"status"`
+    PublishAt  *time.Time `json:"publish_at"`
+    CreatedAt  time.Time  `json:"created_at"`
+    UpdatedAt  time.Time  `json:"updated_at"`
 }
 
 type LoginRequest struct {
@@ -51,44 +64,60 @@ func (u *User) CreateUser(db *sql.DB) error {
     }
     u.ID = int(userId)
 
-    return nil
+    return AssignDefaultRole(db, u.ID)
 }
 
-func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+var ErrUserNotFound = errors.New("user not found")
+
+// IsDuplicateKey reports whether err is MySQL's duplicate entry error, e.g. for a taken username or email
+func IsDuplicateKey(err error) bool {
+    var mysqlErr *mysql.MySQLError
+    return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// userColumns is the column list scanUser expects
+const userColumns = `id, name, username, email, password, created_at, email_verified_at, totp_secret, totp_enabled_at, password_changed_at, bio, suspended_at, suspended_until, suspension_reason`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
     var user User
-    query := `SELECT id, name, username, email, password, created_at FROM users WHERE email = ?`
-    row := db.QueryRow(query, email)
-    fmt.Println("Executing query:", query)
-    err := row.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
+    err := row.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Password, &user.CreatedAt, &user.EmailVerifiedAt, &user.TOTPSecret, &user.TOTPEnabledAt, &user.PasswordChangedAt, &user.Bio,
+        &user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason)
     if err != nil {
         if err == sql.ErrNoRows {
-            return nil, errors.New("user not found")
+            return nil, ErrUserNotFound
         }
         return nil, err
     }
-
     return &user, nil
 }
 
+func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+    query := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+    row := db.QueryRow(query, email)
+    fmt.Println("Executing query:", query)
+    return scanUser(row)
+}
+
+// GetUserByUsername looks up a user by their public username
+func GetUserByUsername(db *sql.DB, username string) (*User, error) {
+    query := `SELECT ` + userColumns + ` FROM users WHERE username = ?`
+    return scanUser(db.QueryRow(query, username))
+}
+
 func GetUserByID(db *sql.DB, userID int) (*User, error) {
-    var user User
-    query := `SELECT id, name, username, email, password, created_at FROM users WHERE id = ?`
+    query := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
     row := db.QueryRow(query, userID)
     fmt.Println("Executing query:", query)
-    err := row.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return nil, errors.New("user not found")
-        }
-        return nil, err
-    }
-
-    return &user, nil
+    return scanUser(row)
 }
 
 func (user *User) UpdateProfile(db *sql.DB) error {
-    query := `UPDATE users SET name = ?, username = ?, email = ? WHERE id = ?`
-    _, err := db.Exec(query, user.Name, user.Username, user.Email, user.ID)
+    query := `UPDATE users SET name = ?, username = ?, email = ?, email_verified_at = ?, bio = ? WHERE id = ?`
+    _, err := db.Exec(query, user.Name, user.Username, user.Email, user.EmailVerifiedAt, user.Bio, user.ID)
     if err != nil {
         // You can log the error here for debugging purposes
         fmt.Println("Error updating user profile:", err)
@@ -97,68 +126,184 @@ func (user *User) UpdateProfile(db *sql.DB) error {
     return nil
 }
 
+// MarkEmailVerified records that the user proved they own their email address
+func MarkEmailVerified(db *sql.DB, userID int) error {
+    _, err := db.Exec(`UPDATE users SET email_verified_at = ? WHERE id = ?`, time.Now(), userID)
+    return err
+}
+
+// UpdatePassword stores a new password hash for the user and records when it changed,
+// so access tokens issued before then stop working. The time is truncated to whole seconds to match the iat claim.
+func UpdatePassword(db *sql.DB, userID int, passwordHash string) error {
+    changedAt := time.Now().Truncate(time.Second)
+    _, err := db.Exec(`UPDATE users SET password = ?, password_changed_at = ? WHERE id = ?`, passwordHash, changedAt, userID)
+    return err
+}
+
+// RehashPassword replaces a password hash with an equivalent one made with newer
+// parameters. Unlike UpdatePassword it doesn't log anyone out, and it only applies
+// if the password hasn't been changed in the meantime.
+func RehashPassword(db *sql.DB, userID int, oldHash, newHash string) error {
+    _, err := db.Exec(`UPDATE users SET password = ? WHERE id = ? AND password = ?`, newHash, userID, oldHash)
+    return err
+}
 
-// CreatePost inserts a new post into the database
+// GetPasswordChangedAt returns when the user's password last changed, or nil if it never has
+func GetPasswordChangedAt(db *sql.DB, userID int) (*time.Time, error) {
+    var changedAt sql.NullTime
+    err := db.QueryRow(`SELECT password_changed_at FROM users WHERE id = ?`, userID).Scan(&changedAt)
+    if err == sql.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    if err != nil || !changedAt.Valid {
+        return nil, err
+    }
+    return &changedAt.Time, nil
+}
+
+
+// CreatePost inserts a new post into the database, saving it as the post's first revision
 func CreatePost(db *sql.DB, post *Post) error {
-    query := `INSERT INTO blogs (title, name, content, username, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
-    _, err := db.Exec(query, post.Title, post.Name, post.Content, post.Username, post.CreatedAt, post.UpdatedAt)
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query := `INSERT INTO blogs (title, name, content, author_id, status, publish_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+    result, err := tx.Exec(query, post.Title, post.Name, post.Content, post.AuthorID, post.Status, post.PublishAt, post.CreatedAt, post.UpdatedAt)
     if err != nil {
         fmt.Println("Error executing query:", err)
         return err
     }
-    return nil
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    post.ID = int(id)
+
+    err = savePostRevision(tx, post, post.AuthorID, post.CreatedAt)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
 }
 
+// postColumns is the column list scanPost expects. The author's username is joined
+// at read time, so renaming an account doesn't detach its posts.
+const postColumns = `b.id, b.name, b.title, b.content, b.author_id, COALESCE(u.username, b.username, ''), b.status, b.publish_at, b.created_at, b.updated_at`
 
+const postsFrom = ` FROM blogs b LEFT JOIN users u ON u.id = b.author_id`
 
+func scanPost(row rowScanner) (*Post, error) {
+    var post Post
+    var authorID sql.NullInt64
+    err := row.Scan(&post.ID, &post.Name, &post.Title, &post.Content, &authorID, &post.Username, &post.Status, &post.PublishAt, &post.CreatedAt, &post.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    if authorID.Valid {
+        id := int(authorID.Int64)
+        post.AuthorID = &id
+    }
+    return &post, nil
+}
 
-// GetAllPosts retrieves all posts from the database
-func GetAllPosts(db *sql.DB) ([]Post, error) {
-    query := `SELECT id, name, title, content, username, created_at, updated_at FROM blogs`
-    rows, err := db.Query(query)
+func queryPosts(db *sql.DB, query string, args ...interface{}) ([]Post, error) {
+    rows, err := db.Query(query, args...)
     if err != nil {
         return nil, err
     }
     defer rows.Close()
 
-    var posts []Post
+    posts := []Post{}
     for rows.Next() {
-        var post Post
-        err := rows.Scan(&post.ID, &post.Name, &post.Title, &post.Content, &post.Username, &post.CreatedAt, &post.UpdatedAt)
+        post, err := scanPost(rows)
         if err != nil {
             return nil, err
         }
-        posts = append(posts, post)
+        posts = append(posts, *post)
     }
+    return posts, rows.Err()
+}
 
-    if err = rows.Err(); err != nil {
-        return nil, err
-    }
+// GetPostsByAuthor retrieves an author's posts, newest first
+func GetPostsByAuthor(db *sql.DB, authorID int) ([]Post, error) {
+    return queryPosts(db, `SELECT `+postColumns+postsFrom+` WHERE b.author_id = ? ORDER BY b.created_at DESC, b.id DESC`, authorID)
+}
 
-    return posts, nil
+// GetPublishedPostsByAuthor retrieves the author's posts the public can see, newest first
+func GetPublishedPostsByAuthor(db *sql.DB, authorID int) ([]Post, error) {
+    return queryPosts(db, `SELECT `+postColumns+postsFrom+` WHERE b.author_id = ? AND b.status = ? ORDER BY b.created_at DESC, b.id DESC`,
+        authorID, PostStatusPublished)
 }
 
 // GetPostByID retrieves a post by its ID
 func GetPostByID(db *sql.DB, postID string) (*Post, error) {
-    var post Post
-    query := `SELECT id, name, title, content, username, created_at, updated_at FROM blogs WHERE id = ?`
-    row := db.QueryRow(query, postID)
-    err := row.Scan(&post.ID, &post.Name, &post.Title, &post.Content, &post.Username, &post.CreatedAt, &post.UpdatedAt)
+    post, err := scanPost(db.QueryRow(`SELECT `+postColumns+postsFrom+` WHERE b.id = ?`, postID))
     if err != nil {
         if err == sql.ErrNoRows {
             return nil, errors.New("post not found")
         }
         return nil, err
     }
-    return &post, nil
+    return post, nil
 }
 
+// GetPostsByIDs retrieves the posts with the given ids, in no particular order.
+// Ids without a post are skipped.
+func GetPostsByIDs(db *sql.DB, ids []int) ([]Post, error) {
+    if len(ids) == 0 {
+        return []Post{}, nil
+    }
+    placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+    args := make([]interface{}, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    return queryPosts(db, `SELECT `+postColumns+postsFrom+` WHERE b.id IN (`+placeholders+`)`, args...)
+}
 
-// UpdatePost updates an existing post in the database
-func UpdatePost(db *sql.DB, post *Post) error {
-    query := `UPDATE blogs SET title = ?, content = ?, updated_at = ? WHERE id = ? AND username = ?`
-    _, err := db.Exec(query, post.Title, post.Content, post.UpdatedAt, post.ID, post.Username)
-    return err
+// IsAuthor reports whether the user wrote the post
+func (post *Post) IsAuthor(userID int) bool {
+    return post.AuthorID != nil && *post.AuthorID == userID
+}
+
+// UpdatePost updates an existing post in the database. A change to the title or
+// content is saved as a new revision credited to editorID.
+func UpdatePost(db *sql.DB, post *Post, editorID int) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Lock the post so concurrent edits get consecutive revision numbers
+    var id int
+    err = tx.QueryRow(`SELECT id FROM blogs WHERE id = ? FOR UPDATE`, post.ID).Scan(&id)
+    if err == sql.ErrNoRows {
+        return errors.New("post not found")
+    }
+    if err != nil {
+        return err
+    }
+    err = saveBaselineRevision(tx, post.ID)
+    if err != nil {
+        return err
+    }
+
+    query := `UPDATE blogs SET title = ?, content = ?, status = ?, publish_at = ?, updated_at = ? WHERE id = ?`
+    _, err = tx.Exec(query, post.Title, post.Content, post.Status, post.PublishAt, post.UpdatedAt, post.ID)
+    if err != nil {
+        return err
+    }
+
+    err = savePostRevision(tx, post, &editorID, post.UpdatedAt)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
 }
 
 // DeletePost deletes a post from the database
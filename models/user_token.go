@@ -0,0 +1,94 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// Purposes of one-time user tokens
+const (
+    TokenPurposePasswordReset     = "password_reset"
+    TokenPurposeEmailVerification = "email_verification"
+    TokenPurposeMFALogin          = "mfa_login"
+    TokenPurposeWebAuthnRegister  = "webauthn_register"
+    TokenPurposeWebAuthnLogin     = "webauthn_login"
+    TokenPurposeMagicLink         = "magic_link"
+)
+
+// for single-use tokens sent to users; only the SHA-256 of the token is stored
+type UserToken struct {
+    ID        int
+    UserID    int
+    Purpose   string
+    TokenHash string
+    ExpiresAt time.Time
+    UsedAt    sql.NullTime
+    CreatedAt time.Time
+}
+
+var ErrUserTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// CreateUserToken stores a new one-time token
+func CreateUserToken(db *sql.DB, token *UserToken) error {
+    query := `INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES (?, ?, ?, ?)`
+    result, err := db.Exec(query, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    token.ID = int(id)
+    return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+// The update is a single statement, so two concurrent requests can't both use the token.
+func ConsumeUserToken(db *sql.DB, purpose, tokenHash string) (*UserToken, error) {
+    now := time.Now()
+    result, err := db.Exec(`UPDATE user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+        now, tokenHash, purpose, now)
+    if err != nil {
+        return nil, err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return nil, err
+    }
+    if affected == 0 {
+        return nil, ErrUserTokenInvalid
+    }
+
+    var token UserToken
+    query := `SELECT id, user_id, purpose, token_hash, expires_at, used_at, created_at FROM user_tokens WHERE token_hash = ?`
+    err = db.QueryRow(query, tokenHash).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+    if err != nil {
+        return nil, err
+    }
+    return &token, nil
+}
+
+// GetValidUserToken looks up an unused, unexpired token without using it up, so a
+// request can be validated before the token is consumed
+func GetValidUserToken(db *sql.DB, purpose, tokenHash string) (*UserToken, error) {
+    var token UserToken
+    query := `SELECT id, user_id, purpose, token_hash, expires_at, used_at, created_at FROM user_tokens
+        WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`
+    err := db.QueryRow(query, tokenHash, purpose, time.Now()).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+    if err == sql.ErrNoRows {
+        return nil, ErrUserTokenInvalid
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &token, nil
+}
+
+// InvalidateUserTokens marks all of a user's outstanding tokens for purpose as used
+func InvalidateUserTokens(db *sql.DB, userID int, purpose string) error {
+    _, err := db.Exec(`UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL`, time.Now(), userID, purpose)
+    return err
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// for passkeys registered by a user
+type WebAuthnCredential struct {
+    ID           int        `json:"id"`
+    UserID       int        `json:"-"`
+    CredentialID []byte     `json:"-"`
+    PublicKey    []byte     `json:"-"`
+    SignCount    uint32     `json:"-"`
+    Name         string     `json:"name"`
+    CreatedAt    time.Time  `json:"created_at"`
+    LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+var ErrWebAuthnCredentialNotFound = errors.New("passkey not found")
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at`
+
+func scanWebAuthnCredential(row rowScanner) (*WebAuthnCredential, error) {
+    var credential WebAuthnCredential
+    err := row.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey,
+        &credential.SignCount, &credential.Name, &credential.CreatedAt, &credential.LastUsedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrWebAuthnCredentialNotFound
+        }
+        return nil, err
+    }
+    return &credential, nil
+}
+
+// CreateWebAuthnCredential stores a newly registered passkey
+func CreateWebAuthnCredential(db *sql.DB, credential *WebAuthnCredential) error {
+    query := `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name) VALUES (?, ?, ?, ?, ?)`
+    result, err := db.Exec(query, credential.UserID, credential.CredentialID, credential.PublicKey, credential.SignCount, credential.Name)
+    if err != nil {
+        return err
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return err
+    }
+    credential.ID = int(id)
+    credential.CreatedAt = time.Now()
+    return nil
+}
+
+// GetWebAuthnCredentialsByUser lists a user's passkeys
+func GetWebAuthnCredentialsByUser(db *sql.DB, userID int) ([]WebAuthnCredential, error) {
+    rows, err := db.Query(`SELECT `+webAuthnCredentialColumns+` FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    credentials := []WebAuthnCredential{}
+    for rows.Next() {
+        credential, err := scanWebAuthnCredential(rows)
+        if err != nil {
+            return nil, err
+        }
+        credentials = append(credentials, *credential)
+    }
+    return credentials, rows.Err()
+}
+
+// GetWebAuthnCredential finds a user's passkey by the authenticator's credential ID
+func GetWebAuthnCredential(db *sql.DB, userID int, credentialID []byte) (*WebAuthnCredential, error) {
+    row := db.QueryRow(`SELECT `+webAuthnCredentialColumns+` FROM webauthn_credentials WHERE user_id = ? AND credential_id = ?`, userID, credentialID)
+    return scanWebAuthnCredential(row)
+}
+
+// WebAuthnCredentialExists reports whether a credential ID is registered to anyone
+func WebAuthnCredentialExists(db *sql.DB, credentialID []byte) (bool, error) {
+    var exists int
+    err := db.QueryRow(`SELECT 1 FROM webauthn_credentials WHERE credential_id = ?`, credentialID).Scan(&exists)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// UpdateWebAuthnCredentialUsage stores the new signature counter after a login
+func UpdateWebAuthnCredentialUsage(db *sql.DB, id int, signCount uint32) error {
+    _, err := db.Exec(`UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ? WHERE id = ?`, signCount, time.Now(), id)
+    return err
+}
+
+// DeleteWebAuthnCredential removes one of the user's passkeys
+func DeleteWebAuthnCredential(db *sql.DB, userID, id int) error {
+    result, err := db.Exec(`DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, userID)
+    if err != nil {
+        return err
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if affected == 0 {
+        return ErrWebAuthnCredentialNotFound
+    }
+    return nil
+}
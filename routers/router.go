@@ -3,23 +3,102 @@ package routers
 import (
     "database/sql"
     "blog-app/controllers"
+    "blog-app/middleware"
+    "blog-app/models"
+    "blog-app/utils"
     "github.com/gorilla/mux"
 )
 
-func InitRouter(db *sql.DB) *mux.Router {
+func InitRouter(db *sql.DB, searcher utils.Searcher) *mux.Router {
     router := mux.NewRouter()
+    mailer := utils.NewMailerFromEnv()
+    webAuthn := utils.WebAuthnConfigFromEnv()
+
+    // Endpoints below require "Authorization: Bearer <token>" from /login or a personal access token
+    protected := router.NewRoute().Subrouter()
+    protected.Use(middleware.RequireAuth(db))
+
+    // Account security endpoints don't accept personal access tokens
+    session := protected.NewRoute().Subrouter()
+    session.Use(middleware.RequireSession)
+
+    // Public keys for verifying our JWTs
+    router.HandleFunc("/.well-known/jwks.json", controllers.JWKS()).Methods("GET")
 
     // User endpoints
-    router.HandleFunc("/register", controllers.Register(db)).Methods("POST")
+    router.HandleFunc("/register", controllers.Register(db, mailer)).Methods("POST")
     router.HandleFunc("/login", controllers.Login(db)).Methods("POST")
-    router.HandleFunc("/profile", controllers.ProfileHandler(db)).Methods("GET", "POST")
+    router.HandleFunc("/login/2fa", controllers.LoginTOTP(db)).Methods("POST")
+    router.HandleFunc("/login/magic", controllers.RequestMagicLink(db, mailer)).Methods("POST")
+    router.HandleFunc("/login/magic/verify", controllers.ConfirmMagicLink()).Methods("GET")
+    router.HandleFunc("/login/magic/verify", controllers.VerifyMagicLink(db)).Methods("POST")
+    router.HandleFunc("/login/webauthn/begin", controllers.BeginPasskeyLogin(db, webAuthn)).Methods("POST")
+    router.HandleFunc("/login/webauthn/finish", controllers.FinishPasskeyLogin(db, webAuthn)).Methods("POST")
+    // Single sign-on, only when an identity provider is configured
+    if oidc := utils.OIDCProviderFromEnv(); oidc != nil {
+        router.HandleFunc("/login/oidc", controllers.StartOIDCLogin(oidc)).Methods("GET")
+        router.HandleFunc("/login/oidc/callback", controllers.FinishOIDCLogin(db, oidc)).Methods("GET")
+        session.HandleFunc("/login/oidc/link", controllers.StartOIDCLink(oidc)).Methods("POST")
+    }
+    router.HandleFunc("/token/refresh", controllers.RefreshToken(db)).Methods("POST")
+    session.HandleFunc("/logout", controllers.Logout(db)).Methods("POST")
+    session.HandleFunc("/sessions", controllers.ListSessions(db)).Methods("GET")
+    session.HandleFunc("/sessions", controllers.RevokeAllSessions(db)).Methods("DELETE")
+    session.HandleFunc("/sessions/{id:[0-9a-f]{32}}", controllers.RevokeSession(db)).Methods("DELETE")
+    session.HandleFunc("/2fa/enroll", controllers.EnrollTOTP(db)).Methods("POST")
+    session.HandleFunc("/2fa/verify", controllers.VerifyTOTP(db)).Methods("POST")
+    session.HandleFunc("/2fa/disable", controllers.DisableTOTP(db)).Methods("POST")
+    session.HandleFunc("/webauthn/register/begin", controllers.BeginPasskeyRegistration(db, webAuthn)).Methods("POST")
+    session.HandleFunc("/webauthn/register/finish", controllers.FinishPasskeyRegistration(db, webAuthn)).Methods("POST")
+    session.HandleFunc("/webauthn/credentials", controllers.ListPasskeys(db)).Methods("GET")
+    session.HandleFunc("/webauthn/credentials/{id:[0-9]+}", controllers.DeletePasskey(db)).Methods("DELETE")
+    router.HandleFunc("/verify-email", controllers.VerifyEmail(db)).Methods("GET")
+    session.HandleFunc("/verify-email/resend", controllers.ResendVerificationEmail(db, mailer)).Methods("POST")
+    router.HandleFunc("/password/forgot", controllers.ForgotPassword(db, mailer)).Methods("POST")
+    router.HandleFunc("/password/reset", controllers.ResetPassword(db)).Methods("POST")
+    session.HandleFunc("/password/change", controllers.ChangePassword(db)).Methods("POST")
+    protected.Handle("/profile", middleware.RequireScope(models.ScopeProfileRead)(controllers.ProfileHandler(db, mailer))).Methods("GET")
+    protected.Handle("/profile", middleware.RequireScope(models.ScopeProfileWrite)(controllers.ProfileHandler(db, mailer))).Methods("POST")
+    session.HandleFunc("/profile/tokens", controllers.ListPersonalAccessTokens(db)).Methods("GET")
+    session.HandleFunc("/profile/tokens", controllers.CreatePersonalAccessToken(db)).Methods("POST")
+    session.HandleFunc("/profile/tokens/{id:[0-9]+}", controllers.RevokePersonalAccessToken(db)).Methods("DELETE")
+    session.HandleFunc("/profile/export", controllers.ExportPersonalData(db)).Methods("GET")
+    session.HandleFunc("/profile/delete", controllers.GetAccountDeletion(db)).Methods("GET")
+    session.HandleFunc("/profile/delete", controllers.RequestAccountDeletion(db, mailer)).Methods("POST")
+    session.HandleFunc("/profile/delete", controllers.CancelAccountDeletion(db)).Methods("DELETE")
+
+    // Public author pages
+    router.HandleFunc("/users/{username}", controllers.GetAuthorProfile(db)).Methods("GET")
 
     // Blog post endpoints
     router.HandleFunc("/posts", controllers.GetAllPosts(db)).Methods("GET") // Fetch all posts
-    router.HandleFunc("/posts/{id:[0-9]+}", controllers.GetPostByID(db)).Methods("GET") // Fetch post by ID
-    router.HandleFunc("/createpost", controllers.CreatePost(db)).Methods("POST") // Create a post
-    router.HandleFunc("/updatepost/{id:[0-9]+}", controllers.UpdatePost(db)).Methods("PUT") // Update a post
-    router.HandleFunc("/deletepost/{id:[0-9]+}", controllers.DeletePost(db)).Methods("DELETE") // Delete a post
+    router.HandleFunc("/posts/search", controllers.SearchPosts(db, searcher)).Methods("GET") // Search posts
+    router.Handle("/posts/{id:[0-9]+}", middleware.OptionalAuth(db)(controllers.GetPostByID(db))).Methods("GET") // Fetch post by ID
+    postsWrite := middleware.RequireScope(models.ScopePostsWrite)
+    canCreatePosts := middleware.RequirePermission(db, models.PermissionPostsCreate)
+    protected.Handle("/me/posts", postsWrite(controllers.ListMyPosts(db))).Methods("GET") // Own posts, including drafts
+    protected.Handle("/createpost", postsWrite(canCreatePosts(controllers.CreatePost(db, searcher)))).Methods("POST") // Create a post
+    protected.Handle("/updatepost/{id:[0-9]+}", postsWrite(controllers.UpdatePost(db, searcher))).Methods("PUT") // Update a post
+    protected.Handle("/deletepost/{id:[0-9]+}", postsWrite(controllers.DeletePost(db, searcher))).Methods("DELETE") // Delete a post
+    protected.Handle("/posts/{id:[0-9]+}/revisions", postsWrite(controllers.ListPostRevisions(db))).Methods("GET")
+    protected.Handle("/posts/{id:[0-9]+}/revisions/diff", postsWrite(controllers.DiffPostRevisions(db))).Methods("GET")
+    protected.Handle("/posts/{id:[0-9]+}/revisions/{revision:[0-9]+}", postsWrite(controllers.GetPostRevision(db))).Methods("GET")
+    protected.Handle("/posts/{id:[0-9]+}/revisions/{revision:[0-9]+}/restore", postsWrite(controllers.RestorePostRevision(db, searcher))).Methods("POST")
+
+    // Admin endpoints
+    canAssignRoles := middleware.RequirePermission(db, models.PermissionRolesAssign)
+    session.Handle("/admin/roles", canAssignRoles(controllers.ListRoles(db))).Methods("GET")
+    session.Handle("/admin/users/{id:[0-9]+}/roles", canAssignRoles(controllers.GetUserRoles(db))).Methods("GET")
+    session.Handle("/admin/users/{id:[0-9]+}/roles", canAssignRoles(controllers.SetUserRoles(db))).Methods("PUT")
+    canManageUsers := middleware.RequirePermission(db, models.PermissionUsersManage)
+    session.Handle("/admin/lockouts", canManageUsers(controllers.ListLockouts(db))).Methods("GET")
+    session.Handle("/admin/lockouts/{id:[0-9]+}", canManageUsers(controllers.ClearLockout(db))).Methods("DELETE")
+    session.Handle("/admin/users", canManageUsers(controllers.ListUsers(db))).Methods("GET")
+    session.Handle("/admin/users/{id:[0-9]+}", canManageUsers(controllers.GetUserDetails(db))).Methods("GET")
+    session.Handle("/admin/users/{id:[0-9]+}", canManageUsers(controllers.RemoveUser(db, searcher))).Methods("DELETE")
+    session.Handle("/admin/users/{id:[0-9]+}/suspend", canManageUsers(controllers.SuspendUser(db))).Methods("POST")
+    session.Handle("/admin/users/{id:[0-9]+}/suspend", canManageUsers(controllers.UnsuspendUser(db))).Methods("DELETE")
+    session.Handle("/admin/users/{id:[0-9]+}/logout", canManageUsers(controllers.ForceLogout(db))).Methods("POST")
 
     return router
 }
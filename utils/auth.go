@@ -1,76 +1,137 @@
 package utils
 
 import (
+    "database/sql"
+    "strconv"
     "time"
     "blog-app/models"
     "github.com/golang-jwt/jwt/v5"
     "errors"
 )
 
-var jwtKey = []byte("your_secret_key")
+// Access tokens are short-lived; clients renew them with a refresh token.
+// Read lazily because main loads .env after package initialisation.
+func accessTokenTTL() time.Duration {
+    return GetEnvDuration("JWT_ACCESS_TTL", 15*time.Minute)
+}
+
+func refreshTokenTTL() time.Duration {
+    return GetEnvDuration("JWT_REFRESH_TTL", 30*24*time.Hour)
+}
 
 type Claims struct {
+    UserID   int    `json:"user_id"`
     Username string `json:"username"`
     Email    string `json:"email"`
+    // SessionID ties the token to a login that can be revoked from /sessions
+    SessionID string `json:"sid,omitempty"`
     jwt.RegisteredClaims
 }
 
-var secretKey = []byte("UrL4/zwWqS7zEwRrNZK3Yp1KCnW+dnTXg1o6jaLBCCw=")
-func GenerateJWT(user *models.User) (string, error) {
-    expirationTime := time.Now().Add(24 * time.Hour)
+func GenerateJWT(user *models.User, sessionID string) (string, error) {
+    jti, err := GenerateRandomToken(16)
+    if err != nil {
+        return "", err
+    }
+
+    expirationTime := time.Now().Add(accessTokenTTL())
     claims := &Claims{
+        UserID:   user.ID,
         Username: user.Username,
         Email:    user.Email,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
+            ID:        jti,
             ExpiresAt: jwt.NewNumericDate(expirationTime),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
             NotBefore: jwt.NewNumericDate(time.Now()),
         },
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString(jwtKey)
+    return signClaims(claims)
 }
 
-func ValidateJWT(tokenString string) (*Claims, error) {
+// ValidateJWT verifies the token signature and expiry, then rejects it if its jti has been
+// revoked, it was issued before the user's last password change, or its session was revoked
+func ValidateJWT(db *sql.DB, tokenString string) (*Claims, error) {
     claims := &Claims{}
 
-    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-        return jwtKey, nil
-    })
+    token, err := parseClaims(tokenString, claims)
 
     if err != nil {
         return nil, err
     }
 
     if !token.Valid {
+        return nil, errors.New("invalid token")
+    }
+
+    // Access tokens carry no audience; a token with one (such as a magic link) was minted for something else
+    if len(claims.Audience) > 0 {
+        return nil, errors.New("token is not an access token")
+    }
+
+    // Tokens without a jti can't be revoked, so they are not accepted
+    if claims.ID == "" {
+        return nil, errors.New("token has no jti")
+    }
+    if claims.UserID == 0 {
+        return nil, errors.New("user_id not found in token")
+    }
+
+    revoked, err := models.IsAccessTokenRevoked(db, claims.ID)
+    if err != nil {
+        return nil, err
+    }
+    if revoked {
+        return nil, errors.New("token has been revoked")
+    }
+
+    // Changing the password logs out every token issued before the change
+    changedAt, err := models.GetPasswordChangedAt(db, claims.UserID)
+    if err != nil {
         return nil, err
     }
+    if changedAt != nil && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(*changedAt)) {
+        return nil, errors.New("token was issued before the password changed")
+    }
+
+    // Revoking a session logs out its access tokens too, not just its refresh tokens
+    if claims.SessionID != "" {
+        session, err := models.GetSession(db, claims.SessionID)
+        if err == models.ErrSessionNotFound {
+            return nil, errors.New("token has an unknown session")
+        }
+        if err != nil {
+            return nil, err
+        }
+        if session.RevokedAt != nil || session.UserID != claims.UserID {
+            return nil, errors.New("session has been revoked")
+        }
+        err = models.TouchSession(db, session.ID, time.Now())
+        if err != nil {
+            return nil, err
+        }
+    }
 
     return claims, nil
 }
 
+// ExtractUserIDFromToken returns the user_id claim of a token signed by any of our keys.
+// Unlike ValidateJWT it does not check revocation.
 func ExtractUserIDFromToken(tokenString string) (string, error) {
-    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-            return nil, errors.New("unexpected signing method")
-        }
-        return secretKey, nil
-    })
+    claims := &Claims{}
+    token, err := parseClaims(tokenString, claims)
     if err != nil {
         return "", err
     }
-
-    claims, ok := token.Claims.(jwt.MapClaims)
-    if !ok || !token.Valid {
+    if !token.Valid {
         return "", errors.New("invalid token")
     }
 
-    userID, ok := claims["user_id"].(string)
-    if !ok {
+    if claims.UserID == 0 {
         return "", errors.New("user_id not found in token")
     }
 
-    return userID, nil
+    return strconv.Itoa(claims.UserID), nil
 }
-
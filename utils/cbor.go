@@ -0,0 +1,188 @@
+package utils
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+)
+
+// decodeCBOR decodes the first CBOR item in data (RFC 8949) and returns it with the
+// number of bytes it used. It supports what WebAuthn needs: definite-length items,
+// integers, byte and text strings, arrays, maps, tags and simple values.
+// Maps decode to map[interface{}]interface{} with int64 or string keys.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+    return decodeCBORItem(data, 0)
+}
+
+const maxCBORDepth = 16
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+    if depth > maxCBORDepth {
+        return nil, 0, errors.New("cbor: nesting too deep")
+    }
+    if len(data) == 0 {
+        return nil, 0, errors.New("cbor: unexpected end of data")
+    }
+
+    major := data[0] >> 5
+    info := data[0] & 0x1f
+
+    // Floats and simple values use the additional info differently
+    if major == 7 {
+        return decodeCBORSimple(data, info)
+    }
+
+    arg, n, err := readCBORArgument(data, info)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    switch major {
+    case 0:
+        if arg > math.MaxInt64 {
+            return nil, 0, errors.New("cbor: integer overflow")
+        }
+        return int64(arg), n, nil
+    case 1:
+        if arg > math.MaxInt64 {
+            return nil, 0, errors.New("cbor: integer overflow")
+        }
+        return -1 - int64(arg), n, nil
+    case 2, 3:
+        if arg > uint64(len(data)-n) {
+            return nil, 0, errors.New("cbor: string length exceeds data")
+        }
+        end := n + int(arg)
+        if major == 2 {
+            b := make([]byte, arg)
+            copy(b, data[n:end])
+            return b, end, nil
+        }
+        return string(data[n:end]), end, nil
+    case 4:
+        if arg > uint64(len(data)) {
+            return nil, 0, errors.New("cbor: array length exceeds data")
+        }
+        items := make([]interface{}, 0, arg)
+        for i := uint64(0); i < arg; i++ {
+            item, used, err := decodeCBORItem(data[n:], depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            items = append(items, item)
+            n += used
+        }
+        return items, n, nil
+    case 5:
+        if arg > uint64(len(data)) {
+            return nil, 0, errors.New("cbor: map length exceeds data")
+        }
+        m := make(map[interface{}]interface{}, arg)
+        for i := uint64(0); i < arg; i++ {
+            key, used, err := decodeCBORItem(data[n:], depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            n += used
+            switch key.(type) {
+            case int64, string:
+            default:
+                return nil, 0, fmt.Errorf("cbor: unsupported map key type %T", key)
+            }
+
+            value, used, err := decodeCBORItem(data[n:], depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            n += used
+            m[key] = value
+        }
+        return m, n, nil
+    case 6:
+        // Tags only add meaning to the following item, which is all we need
+        item, used, err := decodeCBORItem(data[n:], depth+1)
+        if err != nil {
+            return nil, 0, err
+        }
+        return item, n + used, nil
+    }
+    return nil, 0, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+func readCBORArgument(data []byte, info byte) (uint64, int, error) {
+    switch {
+    case info < 24:
+        return uint64(info), 1, nil
+    case info == 24:
+        if len(data) < 2 {
+            return 0, 0, errors.New("cbor: unexpected end of data")
+        }
+        return uint64(data[1]), 2, nil
+    case info == 25:
+        if len(data) < 3 {
+            return 0, 0, errors.New("cbor: unexpected end of data")
+        }
+        return uint64(binary.BigEndian.Uint16(data[1:3])), 3, nil
+    case info == 26:
+        if len(data) < 5 {
+            return 0, 0, errors.New("cbor: unexpected end of data")
+        }
+        return uint64(binary.BigEndian.Uint32(data[1:5])), 5, nil
+    case info == 27:
+        if len(data) < 9 {
+            return 0, 0, errors.New("cbor: unexpected end of data")
+        }
+        return binary.BigEndian.Uint64(data[1:9]), 9, nil
+    }
+    return 0, 0, errors.New("cbor: indefinite lengths are not supported")
+}
+
+func decodeCBORSimple(data []byte, info byte) (interface{}, int, error) {
+    switch info {
+    case 20:
+        return false, 1, nil
+    case 21:
+        return true, 1, nil
+    case 22, 23:
+        return nil, 1, nil
+    case 25:
+        if len(data) < 3 {
+            return nil, 0, errors.New("cbor: unexpected end of data")
+        }
+        return halfToFloat(binary.BigEndian.Uint16(data[1:3])), 3, nil
+    case 26:
+        if len(data) < 5 {
+            return nil, 0, errors.New("cbor: unexpected end of data")
+        }
+        return float64(math.Float32frombits(binary.BigEndian.Uint32(data[1:5]))), 5, nil
+    case 27:
+        if len(data) < 9 {
+            return nil, 0, errors.New("cbor: unexpected end of data")
+        }
+        return math.Float64frombits(binary.BigEndian.Uint64(data[1:9])), 9, nil
+    }
+    return nil, 0, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func halfToFloat(h uint16) float64 {
+    exp := int(h>>10) & 0x1f
+    mant := float64(h & 0x3ff)
+    var value float64
+    switch exp {
+    case 0:
+        value = math.Ldexp(mant, -24)
+    case 31:
+        if mant == 0 {
+            value = math.Inf(1)
+        } else {
+            value = math.NaN()
+        }
+    default:
+        value = math.Ldexp(mant+1024, exp-25)
+    }
+    if h&0x8000 != 0 {
+        return -value
+    }
+    return value
+}
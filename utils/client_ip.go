@@ -0,0 +1,61 @@
+package utils
+
+import (
+    "net"
+    "net/http"
+    "strings"
+)
+
+// ClientIP returns the address the request came from. X-Forwarded-For and X-Real-IP
+// are only trusted when TRUST_PROXY is set, since clients can send them directly.
+func ClientIP(r *http.Request) string {
+    if GetEnvBool("TRUST_PROXY", false) {
+        if forwarded := forwardedFor(r); len(forwarded) > 0 {
+            return forwardedClientIP(forwarded, GetEnvInt("TRUSTED_PROXY_HOPS", 1), r.RemoteAddr)
+        }
+        if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+            return ip.String()
+        }
+    }
+
+    return remoteIP(r.RemoteAddr)
+}
+
+// forwardedFor lists the X-Forwarded-For entries of all such headers, in order
+func forwardedFor(r *http.Request) []string {
+    var entries []string
+    for _, header := range r.Header.Values("X-Forwarded-For") {
+        for _, entry := range strings.Split(header, ",") {
+            entries = append(entries, strings.TrimSpace(entry))
+        }
+    }
+    return entries
+}
+
+// forwardedClientIP picks the client from an X-Forwarded-For list. Each proxy appends
+// the address it got the request from, so only the entries added by our own proxies
+// can be trusted: with hops proxies in front of us, the client is the hops-th entry
+// from the right. Everything left of it may have been made up by the client.
+func forwardedClientIP(entries []string, hops int, remoteAddr string) string {
+    if hops < 1 {
+        hops = 1
+    }
+    // A shorter list means the request skipped the outer proxies, and the entries
+    // were all added by ours
+    i := len(entries) - hops
+    if i < 0 {
+        i = 0
+    }
+    if ip := net.ParseIP(entries[i]); ip != nil {
+        return ip.String()
+    }
+    return remoteIP(remoteAddr)
+}
+
+func remoteIP(remoteAddr string) string {
+    host, _, err := net.SplitHostPort(remoteAddr)
+    if err != nil {
+        return remoteAddr
+    }
+    return host
+}
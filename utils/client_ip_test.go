@@ -0,0 +1,47 @@
+package utils
+
+import (
+    "net/http/httptest"
+    "strconv"
+    "testing"
+)
+
+func TestClientIP(t *testing.T) {
+    tests := []struct {
+        name       string
+        trustProxy bool
+        hops       int
+        forwarded  []string
+        realIP     string
+        want       string
+    }{
+        {name: "no proxy ignores headers", forwarded: []string{"203.0.113.7"}, realIP: "203.0.113.8", want: "192.0.2.1"},
+        {name: "single entry", trustProxy: true, hops: 1, forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+        {name: "spoofed entries on the left", trustProxy: true, hops: 1, forwarded: []string{"10.0.0.1, 203.0.113.7"}, want: "203.0.113.7"},
+        {name: "two proxies", trustProxy: true, hops: 2, forwarded: []string{"10.0.0.1, 203.0.113.7, 198.51.100.2"}, want: "203.0.113.7"},
+        {name: "several headers", trustProxy: true, hops: 2, forwarded: []string{"10.0.0.1", "203.0.113.7, 198.51.100.2"}, want: "203.0.113.7"},
+        {name: "shorter list than hops", trustProxy: true, hops: 3, forwarded: []string{"203.0.113.7, 198.51.100.2"}, want: "203.0.113.7"},
+        {name: "invalid entry", trustProxy: true, hops: 1, forwarded: []string{"203.0.113.7, unknown"}, want: "192.0.2.1"},
+        {name: "X-Real-IP", trustProxy: true, hops: 1, realIP: "203.0.113.8", want: "203.0.113.8"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv("TRUST_PROXY", strconv.FormatBool(tt.trustProxy))
+            t.Setenv("TRUSTED_PROXY_HOPS", strconv.Itoa(tt.hops))
+
+            r := httptest.NewRequest("GET", "/", nil)
+            r.RemoteAddr = "192.0.2.1:4321"
+            for _, header := range tt.forwarded {
+                r.Header.Add("X-Forwarded-For", header)
+            }
+            if tt.realIP != "" {
+                r.Header.Set("X-Real-IP", tt.realIP)
+            }
+
+            if got := ClientIP(r); got != tt.want {
+                t.Fatalf("ClientIP = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "time"
+)
+
+// GetEnvDuration reads a duration such as "15m" from the environment, falling back to def
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+    value := os.Getenv(key)
+    if value == "" {
+        return def
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil {
+        fmt.Printf("Invalid duration for %s (%q), using %s\n", key, value, def)
+        return def
+    }
+    return d
+}
+
+// GetEnvInt reads an integer from the environment, falling back to def
+func GetEnvInt(key string, def int) int {
+    value := os.Getenv(key)
+    if value == "" {
+        return def
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil {
+        fmt.Printf("Invalid integer for %s (%q), using %d\n", key, value, def)
+        return def
+    }
+    return n
+}
+
+// GetEnvBool reads a boolean such as "true" or "0" from the environment, falling back to def
+func GetEnvBool(key string, def bool) bool {
+    value := os.Getenv(key)
+    if value == "" {
+        return def
+    }
+    b, err := strconv.ParseBool(value)
+    if err != nil {
+        fmt.Printf("Invalid boolean for %s (%q), using %t\n", key, value, def)
+        return def
+    }
+    return b
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+    "fmt"
+    "strings"
+)
+
+// maxDiffCells bounds the work of a diff: the changed regions of both texts, multiplied
+// in lines. Bigger changes are shown as a whole removal and insertion rather than line
+// by line. Memory only grows with the number of lines.
+const maxDiffCells = 4000000
+
+type diffLine struct {
+    kind byte // ' ', '-' or '+'
+    text string
+}
+
+// UnifiedDiff compares two texts line by line and returns the differences in unified
+// diff format with context lines around each change, or "" when they are the same
+func UnifiedDiff(fromName, toName, from, to string, context int) string {
+    lines := diffLines(splitLines(from), splitLines(to))
+
+    var changes []int
+    for i, line := range lines {
+        if line.kind != ' ' {
+            changes = append(changes, i)
+        }
+    }
+    if len(changes) == 0 {
+        return ""
+    }
+
+    // fromPos and toPos count the lines of each text before lines[i]
+    fromPos := make([]int, len(lines)+1)
+    toPos := make([]int, len(lines)+1)
+    for i, line := range lines {
+        fromPos[i+1], toPos[i+1] = fromPos[i], toPos[i]
+        if line.kind != '+' {
+            fromPos[i+1]++
+        }
+        if line.kind != '-' {
+            toPos[i+1]++
+        }
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+    for i := 0; i < len(changes); {
+        start := changes[i] - context
+        if start < 0 {
+            start = 0
+        }
+        // Changes close enough to share context go into the same hunk
+        end := changes[i] + 1
+        for i++; i < len(changes) && changes[i]-end <= 2*context; i++ {
+            end = changes[i] + 1
+        }
+        end += context
+        if end > len(lines) {
+            end = len(lines)
+        }
+
+        fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+            hunkRange(fromPos[start], fromPos[end]-fromPos[start]),
+            hunkRange(toPos[start], toPos[end]-toPos[start]))
+        for _, line := range lines[start:end] {
+            b.WriteByte(line.kind)
+            b.WriteString(line.text)
+            b.WriteByte('\n')
+        }
+    }
+    return b.String()
+}
+
+// hunkRange formats a hunk's line range; an empty range names the line before it
+func hunkRange(pos, count int) string {
+    if count == 0 {
+        return fmt.Sprintf("%d,0", pos)
+    }
+    return fmt.Sprintf("%d,%d", pos+1, count)
+}
+
+func splitLines(text string) []string {
+    if text == "" {
+        return nil
+    }
+    return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines aligns the two texts using their longest common subsequence of lines
+func diffLines(a, b []string) []diffLine {
+    // Edits are usually local, so only the part between the common prefix and suffix
+    // needs aligning
+    prefix := 0
+    for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+        prefix++
+    }
+    suffix := 0
+    for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+        suffix++
+    }
+
+    lines := make([]diffLine, 0, len(a)+len(b))
+    for _, text := range a[:prefix] {
+        lines = append(lines, diffLine{' ', text})
+    }
+
+    midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+    if len(midA)*len(midB) > maxDiffCells {
+        for _, text := range midA {
+            lines = append(lines, diffLine{'-', text})
+        }
+        for _, text := range midB {
+            lines = append(lines, diffLine{'+', text})
+        }
+    } else {
+        lines = alignLines(lines, midA, midB)
+    }
+
+    for _, text := range a[len(a)-suffix:] {
+        lines = append(lines, diffLine{' ', text})
+    }
+    return lines
+}
+
+// alignLines appends the alignment of a and b along a longest common subsequence. It is
+// Hirschberg's algorithm: a is split in half and b where the LCS lengths of the two
+// halves add up to the most, so only two rows of LCS lengths are ever kept.
+func alignLines(lines []diffLine, a, b []string) []diffLine {
+    switch {
+    case len(a) == 0:
+        for _, text := range b {
+            lines = append(lines, diffLine{'+', text})
+        }
+        return lines
+    case len(b) == 0:
+        for _, text := range a {
+            lines = append(lines, diffLine{'-', text})
+        }
+        return lines
+    case len(a) == 1:
+        for j, text := range b {
+            if text == a[0] {
+                for _, inserted := range b[:j] {
+                    lines = append(lines, diffLine{'+', inserted})
+                }
+                lines = append(lines, diffLine{' ', text})
+                for _, inserted := range b[j+1:] {
+                    lines = append(lines, diffLine{'+', inserted})
+                }
+                return lines
+            }
+        }
+        lines = append(lines, diffLine{'-', a[0]})
+        for _, text := range b {
+            lines = append(lines, diffLine{'+', text})
+        }
+        return lines
+    }
+
+    mid := len(a) / 2
+    forward := lcsLengths(a[:mid], b, false)
+    backward := lcsLengths(a[mid:], b, true)
+    split, best := 0, -1
+    for j := 0; j <= len(b); j++ {
+        if total := forward[j] + backward[len(b)-j]; total > best {
+            split, best = j, total
+        }
+    }
+
+    lines = alignLines(lines, a[:mid], b[:split])
+    return alignLines(lines, a[mid:], b[split:])
+}
+
+// lcsLengths returns, for each j, the length of the LCS of a and the first j lines of
+// b, or with reverse set, of a and the last j lines of b
+func lcsLengths(a, b []string, reverse bool) []int {
+    prev := make([]int, len(b)+1)
+    row := make([]int, len(b)+1)
+    for i := range a {
+        ai := a[i]
+        if reverse {
+            ai = a[len(a)-1-i]
+        }
+        for j := 1; j <= len(b); j++ {
+            bj := b[j-1]
+            if reverse {
+                bj = b[len(b)-j]
+            }
+            switch {
+            case ai == bj:
+                row[j] = prev[j-1] + 1
+            case prev[j] >= row[j-1]:
+                row[j] = prev[j]
+            default:
+                row[j] = row[j-1]
+            }
+        }
+        prev, row = row, prev
+    }
+    return prev
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+    tests := []struct {
+        name     string
+        from, to string
+        context  int
+        want     string
+    }{
+        {
+            name: "identical",
+            from: "one\ntwo\nthree\n", to: "one\ntwo\nthree\n", context: 3,
+            want: "",
+        },
+        {
+            name: "insert only",
+            from: "one\nthree\n", to: "one\ntwo\nthree\n", context: 3,
+            want: "--- a\n+++ b\n@@ -1,2 +1,3 @@\n one\n+two\n three\n",
+        },
+        {
+            name: "delete only",
+            from: "one\ntwo\nthree\n", to: "one\nthree\n", context: 3,
+            want: "--- a\n+++ b\n@@ -1,3 +1,2 @@\n one\n-two\n three\n",
+        },
+        {
+            name: "into an empty text",
+            from: "", to: "new\n", context: 3,
+            want: "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+new\n",
+        },
+        {
+            name: "change in the middle with context",
+            from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n", to: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n", context: 2,
+            want: "--- a\n+++ b\n@@ -3,5 +3,5 @@\n 3\n 4\n-5\n+five\n 6\n 7\n",
+        },
+        {
+            name: "changes far apart get their own hunks",
+            from: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n", to: "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nK\nl\n", context: 1,
+            want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -10,3 +10,3 @@\n j\n-k\n+K\n l\n",
+        },
+        {
+            name: "unchanged lines between edits are kept",
+            from: "x\na\nb\nc\ny\n", to: "x\nb\nc\nd\ny\n", context: 3,
+            want: "--- a\n+++ b\n@@ -1,5 +1,5 @@\n x\n-a\n b\n c\n+d\n y\n",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := UnifiedDiff("a", "b", tt.from, tt.to, tt.context)
+            if got != tt.want {
+                t.Fatalf("UnifiedDiff =\n%s\nwant\n%s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestUnifiedDiffFindsLongestCommonSubsequence(t *testing.T) {
+    from := strings.Split("a b c a b b a", " ")
+    to := strings.Split("c b a b a c", " ")
+
+    var kept []string
+    removed, added := 0, 0
+    for _, line := range diffLines(from, to) {
+        switch line.kind {
+        case ' ':
+            kept = append(kept, line.text)
+        case '-':
+            removed++
+        case '+':
+            added++
+        }
+    }
+    // The LCS of these has 4 lines, e.g. "b a b a"
+    if len(kept) != 4 || removed != len(from)-4 || added != len(to)-4 {
+        t.Fatalf("kept %v, removed %d, added %d", kept, removed, added)
+    }
+}
+
+func TestUnifiedDiffFallsBackOverCap(t *testing.T) {
+    // 2001 changed lines on each side is just over maxDiffCells, so the shared line in
+    // the middle is shown as removed and added rather than kept
+    var from, to []string
+    for i := 0; i <= 2000; i++ {
+        if i == 1000 {
+            from = append(from, "shared")
+            to = append(to, "shared")
+            continue
+        }
+        from = append(from, fmt.Sprintf("old %d", i))
+        to = append(to, fmt.Sprintf("new %d", i))
+    }
+    if len(from)*len(to) <= maxDiffCells {
+        t.Fatalf("test texts don't exceed maxDiffCells")
+    }
+
+    lines := diffLines(from, to)
+    for i, line := range lines {
+        want := byte('-')
+        if i >= len(from) {
+            want = '+'
+        }
+        if line.kind != want {
+            t.Fatalf("line %d is %q %q, want a whole removal then a whole insertion", i, line.kind, line.text)
+        }
+    }
+
+    diff := UnifiedDiff("a", "b", strings.Join(from, "\n"), strings.Join(to, "\n"), 3)
+    if !strings.HasPrefix(diff, "--- a\n+++ b\n@@ -1,2001 +1,2001 @@\n-old 0\n") || !strings.Contains(diff, "\n-shared\n") || !strings.Contains(diff, "\n+shared\n") {
+        t.Fatalf("unexpected diff over the cap:\n%.200s", diff)
+    }
+}
@@ -0,0 +1,325 @@
+package utils
+
+import (
+    "crypto/ed25519"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "sort"
+    "sync"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one JWT key identified by the "kid" header. Keys without a
+// private part (or secret) can only verify, which is how retired keys are kept
+// around until the tokens they signed have expired.
+type SigningKey struct {
+    ID         string
+    Algorithm  string
+    secret     []byte
+    privateKey interface{}
+    publicKey  interface{}
+}
+
+// KeySet holds every key we accept and the one we currently sign with
+type KeySet struct {
+    Active *SigningKey
+    Keys   map[string]*SigningKey
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+}
+
+// keyFileConfig is the format of the file named by JWT_KEYS_FILE
+type keyFileConfig struct {
+    ActiveKid string `json:"active_kid"`
+    Keys      []struct {
+        Kid            string `json:"kid"`
+        Alg            string `json:"alg"`
+        Secret         string `json:"secret"`
+        SecretFile     string `json:"secret_file"`
+        PrivateKeyFile string `json:"private_key_file"`
+        PublicKeyFile  string `json:"public_key_file"`
+    } `json:"keys"`
+}
+
+var (
+    keySetOnce sync.Once
+    keySet     *KeySet
+    keySetErr  error
+)
+
+// LoadSigningKeys loads the key set from the environment. It is called from main
+// so that a bad configuration stops the server at startup; later calls reuse the result.
+func LoadSigningKeys() (*KeySet, error) {
+    keySetOnce.Do(func() {
+        keySet, keySetErr = loadKeySet()
+    })
+    return keySet, keySetErr
+}
+
+// minHS256SecretLength is the shortest HS256 secret accepted, so it has as many bits as the hash
+const minHS256SecretLength = 32
+
+func loadKeySet() (*KeySet, error) {
+    if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+        return loadKeySetFile(path)
+    }
+
+    secret := os.Getenv("JWT_SECRET")
+    if secret == "" {
+        // Tokens will stop validating after a restart, which is fine for local development only
+        fmt.Println("WARNING: neither JWT_KEYS_FILE nor JWT_SECRET is set, using a random signing key")
+        random, err := GenerateRandomToken(32)
+        if err != nil {
+            return nil, err
+        }
+        secret = random
+    }
+    if len(secret) < minHS256SecretLength {
+        return nil, fmt.Errorf("JWT_SECRET must be at least %d bytes", minHS256SecretLength)
+    }
+
+    kid := os.Getenv("JWT_KID")
+    if kid == "" {
+        kid = "default"
+    }
+    key := &SigningKey{ID: kid, Algorithm: jwt.SigningMethodHS256.Alg(), secret: []byte(secret)}
+    return &KeySet{Active: key, Keys: map[string]*SigningKey{kid: key}}, nil
+}
+
+func loadKeySetFile(path string) (*KeySet, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var config keyFileConfig
+    if err := json.Unmarshal(data, &config); err != nil {
+        return nil, fmt.Errorf("parsing %s: %w", path, err)
+    }
+
+    set := &KeySet{Keys: make(map[string]*SigningKey)}
+    for _, entry := range config.Keys {
+        if entry.Kid == "" {
+            return nil, errors.New("every key needs a kid")
+        }
+        if _, exists := set.Keys[entry.Kid]; exists {
+            return nil, fmt.Errorf("duplicate kid %q", entry.Kid)
+        }
+
+        key := &SigningKey{ID: entry.Kid, Algorithm: entry.Alg}
+        switch entry.Alg {
+        case "HS256":
+            key.secret = []byte(entry.Secret)
+            if entry.SecretFile != "" {
+                key.secret, err = os.ReadFile(entry.SecretFile)
+                if err != nil {
+                    return nil, err
+                }
+            }
+            if len(key.secret) < minHS256SecretLength {
+                return nil, fmt.Errorf("key %q: HS256 secrets must be at least %d bytes", entry.Kid, minHS256SecretLength)
+            }
+        case "RS256", "EdDSA":
+            if entry.PrivateKeyFile != "" {
+                key.privateKey, key.publicKey, err = readPrivateKey(entry.PrivateKeyFile)
+            } else if entry.PublicKeyFile != "" {
+                key.publicKey, err = readPublicKey(entry.PublicKeyFile)
+            } else {
+                err = errors.New("private_key_file or public_key_file is required")
+            }
+            if err != nil {
+                return nil, fmt.Errorf("key %q: %w", entry.Kid, err)
+            }
+            if err := checkKeyType(key); err != nil {
+                return nil, err
+            }
+        default:
+            return nil, fmt.Errorf("key %q: unsupported alg %q", entry.Kid, entry.Alg)
+        }
+        set.Keys[entry.Kid] = key
+    }
+
+    active, ok := set.Keys[config.ActiveKid]
+    if !ok {
+        return nil, fmt.Errorf("active_kid %q does not match any key", config.ActiveKid)
+    }
+    if active.secret == nil && active.privateKey == nil {
+        return nil, fmt.Errorf("active key %q has no private key", active.ID)
+    }
+    set.Active = active
+    return set, nil
+}
+
+func readPrivateKey(path string) (interface{}, interface{}, error) {
+    block, err := readPEM(path)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+        return key, &key.PublicKey, nil
+    }
+    key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, nil, err
+    }
+    switch k := key.(type) {
+    case *rsa.PrivateKey:
+        return k, &k.PublicKey, nil
+    case ed25519.PrivateKey:
+        return k, k.Public(), nil
+    }
+    return nil, nil, errors.New("unsupported private key type")
+}
+
+func readPublicKey(path string) (interface{}, error) {
+    block, err := readPEM(path)
+    if err != nil {
+        return nil, err
+    }
+    return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("%s: no PEM data found", path)
+    }
+    return block, nil
+}
+
+func checkKeyType(key *SigningKey) error {
+    switch key.publicKey.(type) {
+    case *rsa.PublicKey:
+        if key.Algorithm == "RS256" {
+            return nil
+        }
+    case ed25519.PublicKey:
+        if key.Algorithm == "EdDSA" {
+            return nil
+        }
+    }
+    return fmt.Errorf("key %q: key type does not match alg %s", key.ID, key.Algorithm)
+}
+
+func (k *SigningKey) signingMethod() jwt.SigningMethod {
+    return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) signKey() interface{} {
+    if k.secret != nil {
+        return k.secret
+    }
+    return k.privateKey
+}
+
+func (k *SigningKey) verifyKey() interface{} {
+    if k.secret != nil {
+        return k.secret
+    }
+    return k.publicKey
+}
+
+// algorithms lists the algorithms of every configured key, for jwt.WithValidMethods
+func (s *KeySet) algorithms() []string {
+    seen := make(map[string]bool)
+    var algs []string
+    for _, key := range s.Keys {
+        if !seen[key.Algorithm] {
+            seen[key.Algorithm] = true
+            algs = append(algs, key.Algorithm)
+        }
+    }
+    return algs
+}
+
+// keyFunc picks the verification key from the token's kid header
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+    kid, _ := token.Header["kid"].(string)
+    key, ok := s.Keys[kid]
+    if !ok {
+        return nil, fmt.Errorf("unknown kid %q", kid)
+    }
+    if token.Method.Alg() != key.Algorithm {
+        return nil, errors.New("unexpected signing method")
+    }
+    return key.verifyKey(), nil
+}
+
+// JWKS returns the public keys other services need to verify our tokens.
+// HMAC keys are shared secrets and are never published.
+func (s *KeySet) JWKS() []JWK {
+    kids := make([]string, 0, len(s.Keys))
+    for kid := range s.Keys {
+        kids = append(kids, kid)
+    }
+    sort.Strings(kids)
+
+    keys := []JWK{}
+    for _, kid := range kids {
+        key := s.Keys[kid]
+        switch pub := key.publicKey.(type) {
+        case *rsa.PublicKey:
+            keys = append(keys, JWK{
+                Kty: "RSA",
+                Kid: key.ID,
+                Use: "sig",
+                Alg: key.Algorithm,
+                N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+                E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+            })
+        case ed25519.PublicKey:
+            keys = append(keys, JWK{
+                Kty: "OKP",
+                Kid: key.ID,
+                Use: "sig",
+                Alg: key.Algorithm,
+                Crv: "Ed25519",
+                X:   base64.RawURLEncoding.EncodeToString(pub),
+            })
+        }
+    }
+    return keys
+}
+
+// signClaims signs claims with the active key and sets the kid header
+func signClaims(claims jwt.Claims) (string, error) {
+    set, err := LoadSigningKeys()
+    if err != nil {
+        return "", err
+    }
+    token := jwt.NewWithClaims(set.Active.signingMethod(), claims)
+    token.Header["kid"] = set.Active.ID
+    return token.SignedString(set.Active.signKey())
+}
+
+// parseClaims verifies a token against the key named by its kid header
+func parseClaims(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+    set, err := LoadSigningKeys()
+    if err != nil {
+        return nil, err
+    }
+    options = append(options, jwt.WithValidMethods(set.algorithms()))
+    return jwt.ParseWithClaims(tokenString, claims, set.keyFunc, options...)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+    "database/sql"
+    "errors"
+    "time"
+    "blog-app/models"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const magicLinkAudience = "magic-link"
+
+var ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+
+// MagicLinkClaims are signed with the same keys as access tokens but carry their
+// own audience, so neither kind of token is accepted in place of the other
+type MagicLinkClaims struct {
+    UserID int `json:"user_id"`
+    jwt.RegisteredClaims
+}
+
+// GenerateMagicLinkToken returns a signed sign-in token. Its jti is recorded as a
+// one-time user token so the link works only once.
+func GenerateMagicLinkToken(db *sql.DB, user *models.User, ttl time.Duration) (string, error) {
+    jti, err := GenerateRandomToken(16)
+    if err != nil {
+        return "", err
+    }
+
+    expiresAt := time.Now().Add(ttl)
+    err = models.CreateUserToken(db, &models.UserToken{
+        UserID:    user.ID,
+        Purpose:   models.TokenPurposeMagicLink,
+        TokenHash: HashToken(jti),
+        ExpiresAt: expiresAt,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    claims := &MagicLinkClaims{
+        UserID: user.ID,
+        RegisteredClaims: jwt.RegisteredClaims{
+            ID:        jti,
+            Audience:  jwt.ClaimStrings{magicLinkAudience},
+            ExpiresAt: jwt.NewNumericDate(expiresAt),
+            IssuedAt:  jwt.NewNumericDate(time.Now()),
+        },
+    }
+    return signClaims(claims)
+}
+
+// ConsumeMagicLinkToken verifies a sign-in token, marks it used and returns its user ID
+func ConsumeMagicLinkToken(db *sql.DB, tokenString string) (int, error) {
+    claims := &MagicLinkClaims{}
+    token, err := parseClaims(tokenString, claims, jwt.WithAudience(magicLinkAudience), jwt.WithExpirationRequired())
+    if err != nil || !token.Valid || claims.ID == "" {
+        return 0, ErrInvalidMagicLink
+    }
+
+    userToken, err := models.ConsumeUserToken(db, models.TokenPurposeMagicLink, HashToken(claims.ID))
+    if err == models.ErrUserTokenInvalid {
+        return 0, ErrInvalidMagicLink
+    }
+    if err != nil {
+        return 0, err
+    }
+    if userToken.UserID != claims.UserID {
+        return 0, ErrInvalidMagicLink
+    }
+
+    return userToken.UserID, nil
+}
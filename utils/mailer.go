@@ -0,0 +1,106 @@
+package utils
+
+import (
+    "fmt"
+    "net/smtp"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Mailer sends plain-text email
+type Mailer interface {
+    Send(to, subject, body string) error
+}
+
+// SMTPMailer delivers mail through an SMTP server, using PLAIN auth when a username is set
+type SMTPMailer struct {
+    Host     string
+    Port     string
+    Username string
+    Password string
+    From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+    var auth smtp.Auth
+    if m.Username != "" {
+        auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+    }
+
+    msg := strings.Join([]string{
+        "From: " + m.From,
+        "To: " + to,
+        "Subject: " + subject,
+        "Date: " + time.Now().Format(time.RFC1123Z),
+        "MIME-Version: 1.0",
+        "Content-Type: text/plain; charset=UTF-8",
+        "",
+        body,
+    }, "\r\n")
+
+    return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// FileMailer appends messages to a file instead of sending them, or prints them
+// when Path is empty. Meant for local development and tests.
+type FileMailer struct {
+    Path string
+    mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(to, subject, body string) error {
+    entry := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n----\n", to, subject, time.Now().Format(time.RFC3339), body)
+
+    if m.Path == "" {
+        fmt.Print(entry)
+        return nil
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    _, err = f.WriteString(entry)
+    return err
+}
+
+// NewMailerFromEnv returns an SMTPMailer when SMTP_HOST is set, otherwise a FileMailer writing to MAIL_FILE
+func NewMailerFromEnv() Mailer {
+    host := os.Getenv("SMTP_HOST")
+    if host == "" {
+        return &FileMailer{Path: os.Getenv("MAIL_FILE")}
+    }
+
+    port := os.Getenv("SMTP_PORT")
+    if port == "" {
+        port = "587"
+    }
+    from := os.Getenv("MAIL_FROM")
+    if from == "" {
+        from = "no-reply@localhost"
+    }
+
+    return &SMTPMailer{
+        Host:     host,
+        Port:     port,
+        Username: os.Getenv("SMTP_USERNAME"),
+        Password: os.Getenv("SMTP_PASSWORD"),
+        From:     from,
+    }
+}
+
+// AppURL builds a link to the client application from APP_BASE_URL
+func AppURL(path string) string {
+    base := os.Getenv("APP_BASE_URL")
+    if base == "" {
+        base = "http://localhost:8080"
+    }
+    return strings.TrimRight(base, "/") + path
+}
@@ -0,0 +1,388 @@
+package utils
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/big"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const oidcStateAudience = "oidc-state"
+
+// OIDCProvider is an OpenID Connect identity provider used with the
+// authorization code flow and PKCE. Endpoints are found through discovery, so
+// pointing Issuer at a local mock IdP is enough for testing.
+type OIDCProvider struct {
+    Issuer       string
+    ClientID     string
+    ClientSecret string
+    RedirectURL  string
+    Scopes       []string
+    HTTPClient   *http.Client
+
+    mu        sync.Mutex
+    discovery *oidcDiscovery
+    keys      map[string]interface{}
+}
+
+type oidcDiscovery struct {
+    Issuer                string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint         string `json:"token_endpoint"`
+    JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCClaims are the ID token claims we use
+type OIDCClaims struct {
+    Email             string       `json:"email"`
+    EmailVerified     flexibleBool `json:"email_verified"`
+    Name              string       `json:"name"`
+    PreferredUsername string       `json:"preferred_username"`
+    Nonce             string       `json:"nonce"`
+    AuthorizedParty   string       `json:"azp"`
+    jwt.RegisteredClaims
+}
+
+// flexibleBool accepts both true and "true", since some providers send email_verified as a string
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+    value := strings.Trim(string(data), `"`)
+    *b = flexibleBool(value == "true")
+    return nil
+}
+
+// OIDCLoginState is kept between the redirect to the provider and the callback
+type OIDCLoginState struct {
+    State      string `json:"state"`
+    Nonce      string `json:"nonce"`
+    Verifier   string `json:"verifier"`
+    // LinkUserID is set when a logged-in user is linking the provider to their account
+    LinkUserID int    `json:"link_user_id,omitempty"`
+    jwt.RegisteredClaims
+}
+
+// OIDCProviderFromEnv returns nil unless OIDC_ISSUER is set
+func OIDCProviderFromEnv() *OIDCProvider {
+    issuer := os.Getenv("OIDC_ISSUER")
+    if issuer == "" {
+        return nil
+    }
+
+    redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+    if redirectURL == "" {
+        redirectURL = AppURL("/login/oidc/callback")
+    }
+    scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+    if len(scopes) == 0 {
+        scopes = []string{"openid", "email", "profile"}
+    }
+
+    return &OIDCProvider{
+        Issuer:       strings.TrimRight(issuer, "/"),
+        ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+        ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+        RedirectURL:  redirectURL,
+        Scopes:       scopes,
+        HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+    }
+}
+
+// NewOIDCLoginState creates the random state, nonce and PKCE verifier for one login
+func NewOIDCLoginState(ttl time.Duration) (*OIDCLoginState, error) {
+    values := make([]string, 3)
+    for i := range values {
+        value, err := GenerateRandomToken(32)
+        if err != nil {
+            return nil, err
+        }
+        values[i] = value
+    }
+    return &OIDCLoginState{
+        State:    values[0],
+        Nonce:    values[1],
+        Verifier: values[2],
+        RegisteredClaims: jwt.RegisteredClaims{
+            Audience:  jwt.ClaimStrings{oidcStateAudience},
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+        },
+    }, nil
+}
+
+// CodeChallenge is the S256 PKCE challenge for the verifier
+func (s *OIDCLoginState) CodeChallenge() string {
+    sum := sha256.Sum256([]byte(s.Verifier))
+    return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SignOIDCLoginState signs the state so it can be kept in a cookie
+func SignOIDCLoginState(state *OIDCLoginState) (string, error) {
+    return signClaims(state)
+}
+
+// ParseOIDCLoginState verifies a state cookie created by SignOIDCLoginState
+func ParseOIDCLoginState(tokenString string) (*OIDCLoginState, error) {
+    state := &OIDCLoginState{}
+    token, err := parseClaims(tokenString, state, jwt.WithAudience(oidcStateAudience), jwt.WithExpirationRequired())
+    if err != nil {
+        return nil, err
+    }
+    if !token.Valid {
+        return nil, errors.New("invalid login state")
+    }
+    return state, nil
+}
+
+// AuthCodeURL is where the browser is sent to log in at the provider
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state *OIDCLoginState) (string, error) {
+    discovery, err := p.discover(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    params := url.Values{}
+    params.Set("response_type", "code")
+    params.Set("client_id", p.ClientID)
+    params.Set("redirect_uri", p.RedirectURL)
+    params.Set("scope", strings.Join(p.Scopes, " "))
+    params.Set("state", state.State)
+    params.Set("nonce", state.Nonce)
+    params.Set("code_challenge", state.CodeChallenge())
+    params.Set("code_challenge_method", "S256")
+
+    separator := "?"
+    if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+        separator = "&"
+    }
+    return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the verified ID token claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, state *OIDCLoginState) (*OIDCClaims, error) {
+    discovery, err := p.discover(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    form := url.Values{}
+    form.Set("grant_type", "authorization_code")
+    form.Set("code", code)
+    form.Set("redirect_uri", p.RedirectURL)
+    form.Set("code_verifier", state.Verifier)
+    if p.ClientSecret == "" {
+        form.Set("client_id", p.ClientID)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+    if p.ClientSecret != "" {
+        // client_secret_basic; RFC 6749 section 2.3.1 wants both parts form-encoded first
+        req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+    }
+
+    var tokenResponse struct {
+        IDToken string `json:"id_token"`
+    }
+    if err := p.doJSON(req, &tokenResponse); err != nil {
+        return nil, err
+    }
+    if tokenResponse.IDToken == "" {
+        return nil, errors.New("token response has no id_token")
+    }
+
+    return p.VerifyIDToken(ctx, tokenResponse.IDToken, state.Nonce)
+}
+
+// VerifyIDToken checks the ID token signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+    claims := &OIDCClaims{}
+    token, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+        kid, _ := token.Header["kid"].(string)
+        return p.key(ctx, kid)
+    },
+        jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+        jwt.WithIssuer(p.Issuer),
+        jwt.WithAudience(p.ClientID),
+        jwt.WithExpirationRequired(),
+        jwt.WithLeeway(time.Minute),
+    )
+    if err != nil {
+        return nil, err
+    }
+    if !token.Valid {
+        return nil, errors.New("invalid id_token")
+    }
+
+    if claims.Nonce != nonce {
+        return nil, errors.New("id_token nonce does not match")
+    }
+    if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+        return nil, errors.New("id_token azp does not match client ID")
+    }
+    if claims.Subject == "" {
+        return nil, errors.New("id_token has no subject")
+    }
+    return claims, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.discovery != nil {
+        return p.discovery, nil
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+    if err != nil {
+        return nil, err
+    }
+    var discovery oidcDiscovery
+    if err := p.doJSON(req, &discovery); err != nil {
+        return nil, err
+    }
+    if strings.TrimRight(discovery.Issuer, "/") != p.Issuer {
+        return nil, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, p.Issuer)
+    }
+    if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+        return nil, errors.New("discovery document is missing endpoints")
+    }
+
+    p.discovery = &discovery
+    return p.discovery, nil
+}
+
+// key returns the provider's verification key for kid, refetching the JWKS once
+// when the kid is unknown in case the provider rotated its keys
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+    discovery, err := p.discover(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    for attempt := 0; attempt < 2; attempt++ {
+        if p.keys == nil || attempt == 1 {
+            keys, err := p.fetchJWKS(ctx, discovery.JWKSURI)
+            if err != nil {
+                return nil, err
+            }
+            p.keys = keys
+        }
+
+        if key, ok := p.keys[kid]; ok {
+            return key, nil
+        }
+        // Providers with a single key sometimes leave out the kid
+        if kid == "" && len(p.keys) == 1 {
+            for _, key := range p.keys {
+                return key, nil
+            }
+        }
+    }
+    return nil, fmt.Errorf("no provider key with kid %q", kid)
+}
+
+func (p *OIDCProvider) fetchJWKS(ctx context.Context, jwksURI string) (map[string]interface{}, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    var set struct {
+        Keys []struct {
+            Kty string `json:"kty"`
+            Kid string `json:"kid"`
+            Use string `json:"use"`
+            Crv string `json:"crv"`
+            N   string `json:"n"`
+            E   string `json:"e"`
+            X   string `json:"x"`
+            Y   string `json:"y"`
+        } `json:"keys"`
+    }
+    if err := p.doJSON(req, &set); err != nil {
+        return nil, err
+    }
+
+    keys := make(map[string]interface{})
+    for _, jwk := range set.Keys {
+        if jwk.Use != "" && jwk.Use != "sig" {
+            continue
+        }
+        switch {
+        case jwk.Kty == "RSA":
+            n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+            e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+            if errN != nil || errE != nil || len(e) > 4 {
+                continue
+            }
+            keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+        case jwk.Kty == "EC" && (jwk.Crv == "P-256" || jwk.Crv == "P-384"):
+            x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+            y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+            if errX != nil || errY != nil {
+                continue
+            }
+            curve := elliptic.P256()
+            if jwk.Crv == "P-384" {
+                curve = elliptic.P384()
+            }
+            key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+            if !curve.IsOnCurve(key.X, key.Y) {
+                continue
+            }
+            keys[jwk.Kid] = key
+        case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+            x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+            if err != nil || len(x) != ed25519.PublicKeySize {
+                continue
+            }
+            keys[jwk.Kid] = ed25519.PublicKey(x)
+        }
+    }
+    return keys, nil
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) error {
+    client := p.HTTPClient
+    if client == nil {
+        client = http.DefaultClient
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s returned %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+    }
+    return json.Unmarshal(body, v)
+}
@@ -0,0 +1,333 @@
+package utils
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const testOIDCClientID = "blog-app"
+
+// mockIdP is an identity provider serving discovery, a JWKS and a token endpoint that
+// checks PKCE. Each ID token is built from the nonce sent to the authorization
+// endpoint, then passed through editClaims and editHeader before signing.
+type mockIdP struct {
+    server *httptest.Server
+    t      *testing.T
+
+    mu          sync.Mutex
+    keys        map[string]*ecdsa.PrivateKey
+    signingKid  string
+    jwksFetches int
+    codes       map[string]mockAuthorization
+    editClaims  func(jwt.MapClaims)
+    editHeader  func(map[string]interface{})
+}
+
+type mockAuthorization struct {
+    challenge string
+    nonce     string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+    idp := &mockIdP{t: t, codes: map[string]mockAuthorization{}, keys: map[string]*ecdsa.PrivateKey{}}
+    idp.signingKid = idp.addKey("key-1")
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{
+            "issuer":                 idp.server.URL,
+            "authorization_endpoint": idp.server.URL + "/authorize",
+            "token_endpoint":         idp.server.URL + "/token",
+            "jwks_uri":               idp.server.URL + "/jwks",
+        })
+    })
+    mux.HandleFunc("/jwks", idp.serveJWKS)
+    mux.HandleFunc("/token", idp.serveToken)
+    idp.server = httptest.NewServer(mux)
+    t.Cleanup(idp.server.Close)
+    return idp
+}
+
+func (idp *mockIdP) addKey(kid string) string {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        idp.t.Fatal(err)
+    }
+    idp.mu.Lock()
+    defer idp.mu.Unlock()
+    idp.keys[kid] = key
+    return kid
+}
+
+func (idp *mockIdP) provider() *OIDCProvider {
+    return &OIDCProvider{
+        Issuer:      idp.server.URL,
+        ClientID:    testOIDCClientID,
+        RedirectURL: "https://blog.example.com/login/oidc/callback",
+        Scopes:      []string{"openid", "email"},
+        HTTPClient:  idp.server.Client(),
+    }
+}
+
+// authorize plays the browser and the provider's login page: it follows the
+// authorization URL and returns the code the provider redirects back with
+func (idp *mockIdP) authorize(provider *OIDCProvider, state *OIDCLoginState) string {
+    authURL, err := provider.AuthCodeURL(context.Background(), state)
+    if err != nil {
+        idp.t.Fatalf("AuthCodeURL: %v", err)
+    }
+    parsed, err := url.Parse(authURL)
+    if err != nil {
+        idp.t.Fatal(err)
+    }
+    query := parsed.Query()
+    if query.Get("client_id") != testOIDCClientID || query.Get("state") != state.State || query.Get("code_challenge_method") != "S256" {
+        idp.t.Fatalf("unexpected authorization request %s", authURL)
+    }
+
+    code, err := GenerateRandomToken(16)
+    if err != nil {
+        idp.t.Fatal(err)
+    }
+    idp.mu.Lock()
+    defer idp.mu.Unlock()
+    idp.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+    return code
+}
+
+func (idp *mockIdP) serveJWKS(w http.ResponseWriter, r *http.Request) {
+    idp.mu.Lock()
+    defer idp.mu.Unlock()
+    idp.jwksFetches++
+
+    keys := []map[string]string{}
+    for kid, key := range idp.keys {
+        keys = append(keys, map[string]string{
+            "kty": "EC",
+            "crv": "P-256",
+            "kid": kid,
+            "use": "sig",
+            "x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+            "y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+        })
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (idp *mockIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+        http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+        return
+    }
+    if r.PostForm.Get("client_id") != testOIDCClientID {
+        http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+        return
+    }
+
+    idp.mu.Lock()
+    defer idp.mu.Unlock()
+
+    // Codes are single use
+    authorization, ok := idp.codes[r.PostForm.Get("code")]
+    delete(idp.codes, r.PostForm.Get("code"))
+    sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+    if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+        http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+        return
+    }
+
+    now := time.Now()
+    claims := jwt.MapClaims{
+        "iss":            idp.server.URL,
+        "sub":            "subject-1",
+        "aud":            testOIDCClientID,
+        "iat":            now.Unix(),
+        "exp":            now.Add(5 * time.Minute).Unix(),
+        "nonce":          authorization.nonce,
+        "email":          "ada@example.com",
+        "email_verified": true,
+    }
+    if idp.editClaims != nil {
+        idp.editClaims(claims)
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+    token.Header["kid"] = idp.signingKid
+    if idp.editHeader != nil {
+        idp.editHeader(token.Header)
+    }
+    idToken, err := token.SignedString(idp.keys[idp.signingKid])
+    if err != nil {
+        idp.t.Errorf("signing id_token: %v", err)
+        http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+func newTestOIDCLoginState(t *testing.T) *OIDCLoginState {
+    state, err := NewOIDCLoginState(time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return state
+}
+
+func TestOIDCExchange(t *testing.T) {
+    idp := newMockIdP(t)
+    provider := idp.provider()
+    state := newTestOIDCLoginState(t)
+
+    code := idp.authorize(provider, state)
+    claims, err := provider.Exchange(context.Background(), code, state)
+    if err != nil {
+        t.Fatalf("Exchange: %v", err)
+    }
+    if claims.Subject != "subject-1" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+        t.Fatalf("unexpected claims %+v", claims)
+    }
+
+    // A code can't be redeemed twice
+    if _, err := provider.Exchange(context.Background(), code, state); err == nil {
+        t.Fatal("code was redeemed twice")
+    }
+}
+
+func TestOIDCExchangeRejectsInvalidIDTokens(t *testing.T) {
+    tests := []struct {
+        name       string
+        editClaims func(jwt.MapClaims)
+        editHeader func(map[string]interface{})
+        wantErr    string
+    }{
+        {
+            name:       "bad nonce",
+            editClaims: func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+            wantErr:    "nonce",
+        },
+        {
+            name:       "missing nonce",
+            editClaims: func(c jwt.MapClaims) { delete(c, "nonce") },
+            wantErr:    "nonce",
+        },
+        {
+            name:       "wrong audience",
+            editClaims: func(c jwt.MapClaims) { c["aud"] = "another-client" },
+            wantErr:    "aud",
+        },
+        {
+            name: "wrong authorized party",
+            editClaims: func(c jwt.MapClaims) {
+                c["aud"] = []string{testOIDCClientID, "another-client"}
+                c["azp"] = "another-client"
+            },
+            wantErr: "azp",
+        },
+        {
+            name: "missing authorized party with several audiences",
+            editClaims: func(c jwt.MapClaims) {
+                c["aud"] = []string{testOIDCClientID, "another-client"}
+            },
+            wantErr: "azp",
+        },
+        {
+            name:       "wrong issuer",
+            editClaims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+            wantErr:    "iss",
+        },
+        {
+            name:       "expired",
+            editClaims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-5 * time.Minute).Unix() },
+            wantErr:    "expired",
+        },
+        {
+            name:       "no expiry",
+            editClaims: func(c jwt.MapClaims) { delete(c, "exp") },
+            wantErr:    "exp",
+        },
+        {
+            name:       "no subject",
+            editClaims: func(c jwt.MapClaims) { delete(c, "sub") },
+            wantErr:    "subject",
+        },
+        {
+            name:       "unknown kid",
+            editHeader: func(h map[string]interface{}) { h["kid"] = "key-unknown" },
+            wantErr:    "key-unknown",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            idp := newMockIdP(t)
+            idp.editClaims = tt.editClaims
+            idp.editHeader = tt.editHeader
+            provider := idp.provider()
+            state := newTestOIDCLoginState(t)
+
+            claims, err := provider.Exchange(context.Background(), idp.authorize(provider, state), state)
+            if err == nil {
+                t.Fatalf("Exchange accepted the token: %+v", claims)
+            }
+            if !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("got error %q, want it to mention %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestOIDCExchangeRefetchesKeysOnce(t *testing.T) {
+    idp := newMockIdP(t)
+    provider := idp.provider()
+
+    state := newTestOIDCLoginState(t)
+    if _, err := provider.Exchange(context.Background(), idp.authorize(provider, state), state); err != nil {
+        t.Fatalf("Exchange: %v", err)
+    }
+
+    // The provider rotates to a key we haven't seen, so the JWKS is fetched again
+    idp.signingKid = idp.addKey("key-2")
+    state = newTestOIDCLoginState(t)
+    if _, err := provider.Exchange(context.Background(), idp.authorize(provider, state), state); err != nil {
+        t.Fatalf("Exchange after key rotation: %v", err)
+    }
+
+    // A kid the provider doesn't publish costs one more fetch, not one per attempt
+    idp.editHeader = func(h map[string]interface{}) { h["kid"] = "key-unknown" }
+    state = newTestOIDCLoginState(t)
+    if _, err := provider.Exchange(context.Background(), idp.authorize(provider, state), state); err == nil {
+        t.Fatal("token with unknown kid was accepted")
+    }
+    if idp.jwksFetches != 3 {
+        t.Fatalf("JWKS fetched %d times, want 3", idp.jwksFetches)
+    }
+}
+
+func TestOIDCExchangeRejectsPKCEMismatch(t *testing.T) {
+    idp := newMockIdP(t)
+    provider := idp.provider()
+    state := newTestOIDCLoginState(t)
+    code := idp.authorize(provider, state)
+
+    // Someone who stole the code but not the verifier from our state cookie
+    other := newTestOIDCLoginState(t)
+    other.Nonce = state.Nonce
+    _, err := provider.Exchange(context.Background(), code, other)
+    if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+        t.Fatalf("got error %v, want invalid_grant", err)
+    }
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// Supported values of PASSWORD_HASH_ALGORITHM
+const (
+    PasswordHashBcrypt   = "bcrypt"
+    PasswordHashArgon2id = "argon2id"
+)
+
+var ErrUnknownPasswordHash = errors.New("stored password hash has an unknown format")
+
+// PasswordHasher hashes passwords with one algorithm. Hashes are self-describing:
+// the prefix names the algorithm and the parameters used, so several algorithms
+// and cost settings can live side by side in the users table.
+type PasswordHasher interface {
+    // Hash returns the encoded hash of password
+    Hash(password string) (string, error)
+    // Recognizes reports whether hash was produced by this algorithm
+    Recognizes(hash string) bool
+    // Verify checks password against a hash this hasher recognizes
+    Verify(hash, password string) (bool, error)
+    // NeedsRehash reports whether hash was made with different parameters than the current ones
+    NeedsRehash(hash string) bool
+}
+
+// BcryptHasher produces "$2a$<cost>$..." hashes
+type BcryptHasher struct {
+    Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+    return string(hash), err
+}
+
+func (h BcryptHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Verify(hash, password string) (bool, error) {
+    err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+    if err == bcrypt.ErrMismatchedHashAndPassword {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+    cost, err := bcrypt.Cost([]byte(hash))
+    return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher produces hashes in the PHC string format,
+// "$argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>"
+type Argon2idHasher struct {
+    Memory      uint32
+    Iterations  uint32
+    Parallelism uint8
+    SaltLength  int
+    KeyLength   uint32
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+    salt := make([]byte, h.SaltLength)
+    if _, err := rand.Read(salt); err != nil {
+        return "", err
+    }
+    key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+    return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+        base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h Argon2idHasher) Verify(hash, password string) (bool, error) {
+    params, salt, key, err := decodeArgon2id(hash)
+    if err != nil {
+        return false, err
+    }
+    actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+    return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+    params, salt, key, err := decodeArgon2id(hash)
+    if err != nil {
+        return true
+    }
+    return params.Memory != h.Memory || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+        len(salt) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(hash string) (Argon2idHasher, []byte, []byte, error) {
+    var params Argon2idHasher
+    parts := strings.Split(hash, "$")
+    if len(parts) != 6 || parts[1] != "argon2id" {
+        return params, nil, nil, ErrUnknownPasswordHash
+    }
+
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+        return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+    }
+    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+        return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+    }
+
+    salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+    if err != nil {
+        return params, nil, nil, err
+    }
+    key, err := base64.RawStdEncoding.DecodeString(parts[5])
+    if err != nil {
+        return params, nil, nil, err
+    }
+    params.SaltLength = len(salt)
+    params.KeyLength = uint32(len(key))
+    return params, salt, key, nil
+}
+
+// passwordHashers returns every supported hasher with the configured parameters,
+// the one selected by PASSWORD_HASH_ALGORITHM (default argon2id) first
+func passwordHashers() []PasswordHasher {
+    bcryptHasher := BcryptHasher{Cost: GetEnvInt("BCRYPT_COST", bcrypt.DefaultCost)}
+    argon2Hasher := Argon2idHasher{
+        Memory:      uint32(GetEnvInt("ARGON2_MEMORY_KIB", 64*1024)),
+        Iterations:  uint32(GetEnvInt("ARGON2_ITERATIONS", 3)),
+        Parallelism: uint8(GetEnvInt("ARGON2_PARALLELISM", 2)),
+        SaltLength:  16,
+        KeyLength:   32,
+    }
+
+    switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+    case PasswordHashBcrypt:
+        return []PasswordHasher{bcryptHasher, argon2Hasher}
+    case "", PasswordHashArgon2id:
+        return []PasswordHasher{argon2Hasher, bcryptHasher}
+    default:
+        fmt.Printf("Unknown PASSWORD_HASH_ALGORITHM %q, using %s\n", algorithm, PasswordHashArgon2id)
+        return []PasswordHasher{argon2Hasher, bcryptHasher}
+    }
+}
+
+// HashPassword hashes a password with the configured algorithm
+func HashPassword(password string) (string, error) {
+    return passwordHashers()[0].Hash(password)
+}
+
+// VerifyPassword checks a password against a stored hash of any supported algorithm.
+// needsRehash is true when the password is correct but the hash was made with a
+// different algorithm or cost than the configured one, so the caller should store
+// a fresh HashPassword result.
+func VerifyPassword(hash, password string) (ok bool, needsRehash bool, err error) {
+    hashers := passwordHashers()
+    for i, hasher := range hashers {
+        if !hasher.Recognizes(hash) {
+            continue
+        }
+        ok, err = hasher.Verify(hash, password)
+        if err != nil || !ok {
+            return false, false, err
+        }
+        return true, i != 0 || hasher.NeedsRehash(hash), nil
+    }
+    return false, false, ErrUnknownPasswordHash
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+    "bufio"
+    "crypto/sha1"
+    "encoding/hex"
+    "fmt"
+    "math"
+    "os"
+    "strings"
+    "sync"
+    "unicode"
+)
+
+// Reasons a password can be rejected
+const (
+    PasswordTooShort        = "too_short"
+    PasswordTooWeak         = "too_weak"
+    PasswordContainsAccount = "contains_account_name"
+    PasswordBreached        = "breached"
+)
+
+// PasswordViolation is one reason a password was rejected
+type PasswordViolation struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+// PasswordPolicy decides which passwords are acceptable. BreachedHashes holds upper-case
+// SHA-1 hex digests of known leaked passwords.
+type PasswordPolicy struct {
+    MinLength      int
+    MinEntropy     float64
+    BreachedHashes map[string]struct{}
+}
+
+var (
+    passwordPolicyOnce sync.Once
+    passwordPolicy     *PasswordPolicy
+    passwordPolicyErr  error
+)
+
+// LoadPasswordPolicy reads the policy from PASSWORD_MIN_LENGTH (default 8),
+// PASSWORD_MIN_ENTROPY (bits, default 40) and PASSWORD_BREACHED_HASHES_FILE.
+// The file has one SHA-1 hash per line; the "HASH:COUNT" format of the Have I Been
+// Pwned downloads works as is. The policy is loaded once and cached.
+func LoadPasswordPolicy() (*PasswordPolicy, error) {
+    passwordPolicyOnce.Do(func() {
+        policy := &PasswordPolicy{
+            MinLength:      GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+            MinEntropy:     float64(GetEnvInt("PASSWORD_MIN_ENTROPY", 40)),
+            BreachedHashes: map[string]struct{}{},
+        }
+        if path := os.Getenv("PASSWORD_BREACHED_HASHES_FILE"); path != "" {
+            passwordPolicyErr = policy.loadBreachedHashes(path)
+        }
+        passwordPolicy = policy
+    })
+    return passwordPolicy, passwordPolicyErr
+}
+
+func (p *PasswordPolicy) loadBreachedHashes(path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line++ {
+        hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+        if hash == "" || strings.HasPrefix(hash, "#") {
+            continue
+        }
+        if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+            return fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+        }
+        p.BreachedHashes[strings.ToUpper(hash)] = struct{}{}
+    }
+    return scanner.Err()
+}
+
+// Check returns every rule the password breaks, or nil if it is acceptable.
+// username and email are the account's, so the password can't be built from them.
+func (p *PasswordPolicy) Check(password, username, email string) []PasswordViolation {
+    var violations []PasswordViolation
+
+    if len([]rune(password)) < p.MinLength {
+        violations = append(violations, PasswordViolation{PasswordTooShort,
+            fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+    }
+
+    if PasswordEntropy(password) < p.MinEntropy {
+        violations = append(violations, PasswordViolation{PasswordTooWeak,
+            "Password is too easy to guess, use a longer password or mix letters, digits and symbols"})
+    }
+
+    lower := strings.ToLower(password)
+    localPart, _, _ := strings.Cut(email, "@")
+    for _, name := range []string{username, localPart} {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if len(name) >= 3 && strings.Contains(lower, name) {
+            violations = append(violations, PasswordViolation{PasswordContainsAccount,
+                "Password must not contain your username or email"})
+            break
+        }
+    }
+
+    sum := sha1.Sum([]byte(password))
+    if _, ok := p.BreachedHashes[strings.ToUpper(hex.EncodeToString(sum[:]))]; ok {
+        violations = append(violations, PasswordViolation{PasswordBreached,
+            "Password has appeared in a data breach, choose a different one"})
+    }
+
+    return violations
+}
+
+// PasswordEntropy estimates the strength of a password in bits from the character
+// classes it uses. Repeated characters only count once per run, so "aaaaaaaa" is weak.
+func PasswordEntropy(password string) float64 {
+    var lower, upper, digit, symbol, other bool
+    length := 0
+    var previous rune
+    for i, r := range password {
+        if i == 0 || r != previous {
+            length++
+        }
+        previous = r
+
+        switch {
+        case r >= 'a' && r <= 'z':
+            lower = true
+        case r >= 'A' && r <= 'Z':
+            upper = true
+        case r >= '0' && r <= '9':
+            digit = true
+        case r <= unicode.MaxASCII:
+            symbol = true
+        default:
+            other = true
+        }
+    }
+
+    pool := 0
+    for _, class := range []struct {
+        used bool
+        size int
+    }{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+        if class.used {
+            pool += class.size
+        }
+    }
+    if pool == 0 {
+        return 0
+    }
+    return float64(length) * math.Log2(float64(pool))
+}
+
+// CheckPassword applies the configured policy
+func CheckPassword(password, username, email string) ([]PasswordViolation, error) {
+    policy, err := LoadPasswordPolicy()
+    if err != nil {
+        return nil, err
+    }
+    return policy.Check(password, username, email), nil
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+    "blog-app/models"
+    "database/sql"
+    "fmt"
+    "html"
+    "os"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// SearchHit is a post matching a search, with its relevance score
+type SearchHit struct {
+    PostID int
+    Score  float64
+}
+
+// Searcher finds posts by the words in their title and content. Hits come back best
+// match first, along with the total number of matches for paging.
+type Searcher interface {
+    Search(query string, limit, offset int) ([]SearchHit, int, error)
+    // IndexPost is called whenever a post is created or changed
+    IndexPost(post *models.Post) error
+    RemovePost(postID int) error
+}
+
+// NewSearcherFromEnv returns the backend named by SEARCH_BACKEND: "mysql" (the default)
+// uses the FULLTEXT index on blogs, "memory" builds a TF-IDF index from all posts at startup
+func NewSearcherFromEnv(db *sql.DB) (Searcher, error) {
+    backend := os.Getenv("SEARCH_BACKEND")
+    switch backend {
+    case "", "mysql":
+        return &MySQLSearcher{DB: db}, nil
+    case "memory":
+        searcher := NewMemorySearcher()
+        err := searcher.Load(db)
+        if err != nil {
+            return nil, err
+        }
+        return searcher, nil
+    default:
+        return nil, fmt.Errorf("unknown SEARCH_BACKEND %q, expected mysql or memory", backend)
+    }
+}
+
+// searchToken is a word in a text, with its byte offsets
+type searchToken struct {
+    start, end int
+    term       string
+}
+
+// scanSearchTokens splits text into lowercased words of letters and digits.
+// Single characters are skipped; they match too much to be useful.
+func scanSearchTokens(text string) []searchToken {
+    var tokens []searchToken
+    start := -1
+    for i := 0; i <= len(text); {
+        r, size := utf8.RuneError, 0
+        if i < len(text) {
+            r, size = utf8.DecodeRuneInString(text[i:])
+        }
+        isWord := size > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r))
+        if isWord && start < 0 {
+            start = i
+        }
+        if !isWord && start >= 0 {
+            if utf8.RuneCountInString(text[start:i]) > 1 {
+                tokens = append(tokens, searchToken{start: start, end: i, term: strings.ToLower(text[start:i])})
+            }
+            start = -1
+        }
+        if size == 0 {
+            break
+        }
+        i += size
+    }
+    return tokens
+}
+
+// SearchTerms returns the distinct words of a search query
+func SearchTerms(query string) []string {
+    seen := map[string]bool{}
+    var terms []string
+    for _, token := range scanSearchTokens(query) {
+        if !seen[token.term] {
+            seen[token.term] = true
+            terms = append(terms, token.term)
+        }
+    }
+    return terms
+}
+
+// HighlightSearchTerms HTML-escapes text and wraps every word matching a term in <mark>
+func HighlightSearchTerms(text string, terms []string) string {
+    return highlightRange(text, scanSearchTokens(text), termSet(terms), 0, len(text))
+}
+
+// SearchSnippet returns about size bytes of text around the first matching word,
+// highlighted like HighlightSearchTerms, with "…" where text was cut off
+func SearchSnippet(text string, terms []string, size int) string {
+    tokens := scanSearchTokens(text)
+    if len(text) <= size || len(tokens) == 0 {
+        return HighlightSearchTerms(text, terms)
+    }
+    matches := termSet(terms)
+
+    // Start a little before the first match so it has some context
+    first := 0
+    for i, token := range tokens {
+        if matches[token.term] {
+            first = i
+            break
+        }
+    }
+    start := 0
+    for i := first; i >= 0; i-- {
+        if tokens[first].start-tokens[i].start > size/4 {
+            break
+        }
+        start = tokens[i].start
+    }
+    if first == 0 {
+        start = 0
+    }
+
+    // End on a whole word
+    end := start
+    for _, token := range tokens {
+        if token.start >= start && token.end-start <= size {
+            end = token.end
+        }
+    }
+
+    snippet := highlightRange(text, tokens, matches, start, end)
+    if start > 0 {
+        snippet = "…" + snippet
+    }
+    if end < len(text) {
+        snippet += "…"
+    }
+    return snippet
+}
+
+func termSet(terms []string) map[string]bool {
+    set := make(map[string]bool, len(terms))
+    for _, term := range terms {
+        set[strings.ToLower(term)] = true
+    }
+    return set
+}
+
+func highlightRange(text string, tokens []searchToken, matches map[string]bool, start, end int) string {
+    var b strings.Builder
+    pos := start
+    for _, token := range tokens {
+        if token.start < start || token.end > end || !matches[token.term] {
+            continue
+        }
+        b.WriteString(html.EscapeString(text[pos:token.start]))
+        b.WriteString("<mark>")
+        b.WriteString(html.EscapeString(text[token.start:token.end]))
+        b.WriteString("</mark>")
+        pos = token.end
+    }
+    b.WriteString(html.EscapeString(text[pos:end]))
+    return b.String()
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+    "blog-app/models"
+    "database/sql"
+    "math"
+    "sort"
+    "sync"
+)
+
+// searchTitleWeight makes a word in the title count as much as this many in the content
+const searchTitleWeight = 2
+
+// MemorySearcher is a pure-Go TF-IDF index of published posts kept in memory. It needs
+// no database support, which makes it useful in tests and with stores other than MySQL,
+// but it has to be rebuilt with Load whenever the process starts.
+type MemorySearcher struct {
+    mu sync.RWMutex
+    // postings maps each term to the weighted count of that term in each post
+    postings map[string]map[int]float64
+    // terms lists the terms of each post, so it can be removed again
+    terms map[int][]string
+    // lengths holds the total weight of each post's words
+    lengths map[int]float64
+}
+
+func NewMemorySearcher() *MemorySearcher {
+    return &MemorySearcher{
+        postings: map[string]map[int]float64{},
+        terms:    map[int][]string{},
+        lengths:  map[int]float64{},
+    }
+}
+
+// Load indexes every published post in the database, a page at a time
+func (s *MemorySearcher) Load(db *sql.DB) error {
+    var after *models.PostCursor
+    for {
+        posts, next, err := models.ListPosts(db, models.PostFilter{Status: models.PostStatusPublished}, after, models.MaxPostPageSize)
+        if err != nil {
+            return err
+        }
+        for i := range posts {
+            s.IndexPost(&posts[i])
+        }
+        if next == nil {
+            return nil
+        }
+        after = next
+    }
+}
+
+func (s *MemorySearcher) IndexPost(post *models.Post) error {
+    if !post.IsPublished() {
+        return s.RemovePost(post.ID)
+    }
+
+    counts := map[string]float64{}
+    length := 0.0
+    for _, token := range scanSearchTokens(post.Title) {
+        counts[token.term] += searchTitleWeight
+        length += searchTitleWeight
+    }
+    for _, token := range scanSearchTokens(post.Content) {
+        counts[token.term]++
+        length++
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.remove(post.ID)
+    terms := make([]string, 0, len(counts))
+    for term, count := range counts {
+        if s.postings[term] == nil {
+            s.postings[term] = map[int]float64{}
+        }
+        s.postings[term][post.ID] = count
+        terms = append(terms, term)
+    }
+    s.terms[post.ID] = terms
+    s.lengths[post.ID] = length
+    return nil
+}
+
+func (s *MemorySearcher) RemovePost(postID int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.remove(postID)
+    return nil
+}
+
+func (s *MemorySearcher) remove(postID int) {
+    for _, term := range s.terms[postID] {
+        delete(s.postings[term], postID)
+        if len(s.postings[term]) == 0 {
+            delete(s.postings, term)
+        }
+    }
+    delete(s.terms, postID)
+    delete(s.lengths, postID)
+}
+
+// Search scores each post by the sum over the query terms of
+// (1 + ln tf) * (1 + ln(N / df)), divided by the square root of the post's length
+// so long posts don't win just by having more words
+func (s *MemorySearcher) Search(query string, limit, offset int) ([]SearchHit, int, error) {
+    s.mu.RLock()
+    scores := map[int]float64{}
+    total := float64(len(s.lengths))
+    for _, term := range SearchTerms(query) {
+        posts := s.postings[term]
+        if len(posts) == 0 {
+            continue
+        }
+        idf := 1 + math.Log(total/float64(len(posts)))
+        for postID, count := range posts {
+            scores[postID] += (1 + math.Log(count)) * idf
+        }
+    }
+    hits := make([]SearchHit, 0, len(scores))
+    for postID, score := range scores {
+        hits = append(hits, SearchHit{PostID: postID, Score: score / math.Sqrt(s.lengths[postID])})
+    }
+    s.mu.RUnlock()
+
+    sort.Slice(hits, func(i, j int) bool {
+        if hits[i].Score != hits[j].Score {
+            return hits[i].Score > hits[j].Score
+        }
+        return hits[i].PostID > hits[j].PostID
+    })
+
+    count := len(hits)
+    if offset < 0 {
+        offset = 0
+    }
+    if limit < 0 {
+        limit = 0
+    }
+    if offset >= count {
+        return []SearchHit{}, count, nil
+    }
+    hits = hits[offset:]
+    if len(hits) > limit {
+        hits = hits[:limit]
+    }
+    return hits, count, nil
+}
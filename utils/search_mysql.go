@@ -0,0 +1,50 @@
+package utils
+
+import (
+    "blog-app/models"
+    "database/sql"
+)
+
+// MySQLSearcher ranks published posts with MySQL's natural language full-text search over the
+// ft_blogs_title_content index. MySQL keeps the index up to date by itself.
+type MySQLSearcher struct {
+    DB *sql.DB
+}
+
+const searchMatch = `MATCH(title, content) AGAINST (? IN NATURAL LANGUAGE MODE)`
+
+const searchPublished = `status = '` + models.PostStatusPublished + `'`
+
+func (s *MySQLSearcher) Search(query string, limit, offset int) ([]SearchHit, int, error) {
+    var total int
+    err := s.DB.QueryRow(`SELECT COUNT(*) FROM blogs WHERE `+searchMatch+` AND `+searchPublished, query).Scan(&total)
+    if err != nil || total == 0 {
+        return []SearchHit{}, 0, err
+    }
+
+    rows, err := s.DB.Query(`SELECT id, `+searchMatch+` AS score FROM blogs WHERE `+searchMatch+` AND `+searchPublished+`
+        ORDER BY score DESC, id DESC LIMIT ? OFFSET ?`, query, query, limit, offset)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    hits := []SearchHit{}
+    for rows.Next() {
+        var hit SearchHit
+        err := rows.Scan(&hit.PostID, &hit.Score)
+        if err != nil {
+            return nil, 0, err
+        }
+        hits = append(hits, hit)
+    }
+    return hits, total, rows.Err()
+}
+
+func (s *MySQLSearcher) IndexPost(post *models.Post) error {
+    return nil
+}
+
+func (s *MySQLSearcher) RemovePost(postID int) error {
+    return nil
+}
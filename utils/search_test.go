@@ -0,0 +1,179 @@
+package utils
+
+import (
+    "blog-app/models"
+    "fmt"
+    "reflect"
+    "testing"
+    "unicode/utf8"
+)
+
+func testSearchPost(id int, status, title, content string) *models.Post {
+    return &models.Post{ID: id, Status: status, Title: title, Content: content}
+}
+
+func newTestMemorySearcher(t *testing.T, posts ...*models.Post) *MemorySearcher {
+    s := NewMemorySearcher()
+    for _, post := range posts {
+        if err := s.IndexPost(post); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return s
+}
+
+func searchIDs(t *testing.T, s *MemorySearcher, query string, limit, offset int) ([]int, int) {
+    hits, total, err := s.Search(query, limit, offset)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ids := []int{}
+    for _, hit := range hits {
+        ids = append(ids, hit.PostID)
+    }
+    return ids, total
+}
+
+func TestMemorySearcherRanking(t *testing.T) {
+    s := newTestMemorySearcher(t,
+        testSearchPost(1, models.PostStatusPublished, "Go concurrency patterns", "Goroutines and channels make concurrency in go simple."),
+        testSearchPost(2, models.PostStatusPublished, "Baking bread", "Knead the dough, then let it rest. You can go for a walk meanwhile."),
+        testSearchPost(3, models.PostStatusPublished, "Databases", "Indexing strategies for mysql and postgres, with examples in go and python."),
+        testSearchPost(4, models.PostStatusDraft, "Go generics", "A draft about go generics."),
+    )
+
+    tests := []struct {
+        name  string
+        query string
+        want  []int
+    }{
+        // Post 1 has the word in its title; of the others the shorter post wins
+        {name: "title outweighs content", query: "go", want: []int{1, 3, 2}},
+        {name: "rare word outweighs common one", query: "GO Bread", want: []int{2, 1, 3}},
+        {name: "several terms", query: "python mysql", want: []int{3}},
+        {name: "punctuation is ignored", query: "concurrency!", want: []int{1}},
+        {name: "drafts are not indexed", query: "generics", want: []int{}},
+        {name: "single characters are ignored", query: "a", want: []int{}},
+        {name: "no match", query: "kubernetes", want: []int{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ids, total := searchIDs(t, s, tt.query, 10, 0)
+            if !reflect.DeepEqual(ids, tt.want) || total != len(tt.want) {
+                t.Fatalf("Search(%q) = %v (total %d), want %v", tt.query, ids, total, tt.want)
+            }
+        })
+    }
+}
+
+func TestMemorySearcherPaging(t *testing.T) {
+    var posts []*models.Post
+    for id := 1; id <= 5; id++ {
+        posts = append(posts, testSearchPost(id, models.PostStatusPublished, fmt.Sprintf("Post %d", id), "About go"))
+    }
+    s := newTestMemorySearcher(t, posts...)
+
+    tests := []struct {
+        limit, offset int
+        want          []int
+    }{
+        // Equal scores are ordered newest post first
+        {limit: 2, offset: 0, want: []int{5, 4}},
+        {limit: 2, offset: 2, want: []int{3, 2}},
+        {limit: 2, offset: 4, want: []int{1}},
+        {limit: 2, offset: 5, want: []int{}},
+        {limit: 10, offset: 0, want: []int{5, 4, 3, 2, 1}},
+        {limit: 2, offset: -3, want: []int{5, 4}},
+        {limit: -1, offset: 0, want: []int{}},
+    }
+    for _, tt := range tests {
+        ids, total := searchIDs(t, s, "go", tt.limit, tt.offset)
+        if !reflect.DeepEqual(ids, tt.want) || total != 5 {
+            t.Errorf("Search limit %d offset %d = %v (total %d), want %v (total 5)", tt.limit, tt.offset, ids, total, tt.want)
+        }
+    }
+}
+
+func TestMemorySearcherRemovesPosts(t *testing.T) {
+    s := newTestMemorySearcher(t,
+        testSearchPost(1, models.PostStatusPublished, "Go concurrency", "Channels in go."),
+        testSearchPost(2, models.PostStatusPublished, "Baking bread", "You can go for a walk."),
+        testSearchPost(3, models.PostStatusPublished, "Databases", "Examples in go and python."),
+    )
+
+    // Unpublishing re-indexes the post as a draft
+    s.IndexPost(testSearchPost(1, models.PostStatusDraft, "Go concurrency", "Channels in go."))
+    if ids, total := searchIDs(t, s, "concurrency", 10, 0); len(ids) != 0 || total != 0 {
+        t.Fatalf("unpublished post still found: %v (total %d)", ids, total)
+    }
+
+    // Editing a post drops the words it no longer has
+    s.IndexPost(testSearchPost(3, models.PostStatusPublished, "Databases", "Examples in go."))
+    if ids, _ := searchIDs(t, s, "python", 10, 0); len(ids) != 0 {
+        t.Fatalf("removed word still found in %v", ids)
+    }
+
+    s.RemovePost(2)
+    if ids, total := searchIDs(t, s, "go", 10, 0); !reflect.DeepEqual(ids, []int{3}) || total != 1 {
+        t.Fatalf("Search after delete = %v (total %d), want [3]", ids, total)
+    }
+
+    s.RemovePost(3)
+    if len(s.postings) != 0 || len(s.terms) != 0 || len(s.lengths) != 0 {
+        t.Fatalf("index not empty after removing every post: %d terms, %d posts", len(s.postings), len(s.lengths))
+    }
+}
+
+func TestHighlightSearchTerms(t *testing.T) {
+    tests := []struct {
+        name  string
+        text  string
+        query string
+        want  string
+    }{
+        {name: "case-insensitive", text: "Go and GO and go", query: "go", want: "<mark>Go</mark> and <mark>GO</mark> and <mark>go</mark>"},
+        {name: "whole words only", text: "going to go", query: "go", want: "going to <mark>go</mark>"},
+        {name: "HTML around a match", text: "<b>Go</b> & go-lang", query: "go", want: "&lt;b&gt;<mark>Go</mark>&lt;/b&gt; &amp; <mark>go</mark>-lang"},
+        {name: "script is escaped", text: `<script>alert("go")</script>`, query: "go", want: `&lt;script&gt;alert(&#34;<mark>go</mark>&#34;)&lt;/script&gt;`},
+        {name: "query can't add markup", text: "use <mark> tags", query: "<mark>", want: "use &lt;<mark>mark</mark>&gt; tags"},
+        {name: "no match", text: "fish & chips", query: "go", want: "fish &amp; chips"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := HighlightSearchTerms(tt.text, SearchTerms(tt.query))
+            if got != tt.want {
+                t.Fatalf("HighlightSearchTerms(%q, %q) = %q, want %q", tt.text, tt.query, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSearchSnippet(t *testing.T) {
+    const fox = "The quick brown fox jumps over the lazy dog near the river bank"
+    tests := []struct {
+        name  string
+        text  string
+        query string
+        size  int
+        want  string
+    }{
+        {name: "short text is kept whole", text: "Go is fun", query: "go", size: 20, want: "<mark>Go</mark> is fun"},
+        {name: "match near the start", text: fox, query: "quick", size: 20, want: "The <mark>quick</mark> brown fox…"},
+        {name: "match in the middle", text: fox, query: "lazy", size: 20, want: "…the <mark>lazy</mark> dog near…"},
+        {name: "match at the end", text: fox, query: "bank", size: 20, want: "…<mark>bank</mark>"},
+        {name: "no match", text: fox, query: "zebra", size: 20, want: "The quick brown fox…"},
+        {name: "multi-byte words", text: "café crème brûlée served with a long line of words after it", query: "crème", size: 20, want: "…<mark>crème</mark> brûlée…"},
+        {name: "escaped", text: "first words here, then x<go>&y and more words after", query: "go", size: 20, want: "…<mark>go</mark>&gt;&amp;y and more words…"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := SearchSnippet(tt.text, SearchTerms(tt.query), tt.size)
+            if got != tt.want {
+                t.Fatalf("SearchSnippet(%q) = %q, want %q", tt.query, got, tt.want)
+            }
+            if !utf8.ValidString(got) {
+                t.Fatalf("snippet %q is not valid UTF-8", got)
+            }
+        })
+    }
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "time"
+    "blog-app/models"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+var ErrAccountSuspended = errors.New("account is suspended")
+
+// TokenPair is returned to clients on login and refresh
+type TokenPair struct {
+    AccessToken  string `json:"token"`
+    RefreshToken string `json:"refresh_token"`
+    TokenType    string `json:"token_type"`
+    ExpiresIn    int    `json:"expires_in"`
+}
+
+// GenerateRandomToken returns n random bytes encoded as URL-safe base64
+func GenerateRandomToken(n int) (string, error) {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, which is what gets stored in the database
+func HashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens starts a new login: a session for the device in r, an access token and
+// the first refresh token of a new family. The family ID doubles as the session ID.
+func IssueTokens(db *sql.DB, user *models.User, r *http.Request) (*TokenPair, error) {
+    familyBytes := make([]byte, 16)
+    if _, err := rand.Read(familyBytes); err != nil {
+        return nil, err
+    }
+    familyID := hex.EncodeToString(familyBytes)
+
+    if err := createSession(db, user.ID, familyID, r); err != nil {
+        return nil, err
+    }
+
+    refreshToken, raw, err := newRefreshToken(user.ID, familyID)
+    if err != nil {
+        return nil, err
+    }
+    if err := models.CreateRefreshToken(db, refreshToken); err != nil {
+        return nil, err
+    }
+
+    return newTokenPair(user, familyID, raw)
+}
+
+func createSession(db *sql.DB, userID int, id string, r *http.Request) error {
+    userAgent := r.UserAgent()
+    if len(userAgent) > 255 {
+        userAgent = userAgent[:255]
+    }
+    now := time.Now()
+    return models.CreateSession(db, &models.Session{
+        ID:         id,
+        UserID:     userID,
+        UserAgent:  userAgent,
+        IP:         ClientIP(r),
+        CreatedAt:  now,
+        LastSeenAt: now,
+    })
+}
+
+// RefreshTokens exchanges a refresh token for a new pair. Each refresh token works once;
+// presenting one that was already used revokes the whole family, since it means it was copied.
+func RefreshTokens(db *sql.DB, raw string, r *http.Request) (*TokenPair, error) {
+    current, err := models.GetRefreshTokenByHash(db, HashToken(raw))
+    if err == models.ErrRefreshTokenNotFound {
+        return nil, ErrInvalidRefreshToken
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if current.RevokedAt.Valid {
+        if err := models.RevokeRefreshTokenFamily(db, current.FamilyID); err != nil {
+            return nil, err
+        }
+        return nil, ErrInvalidRefreshToken
+    }
+    if time.Now().After(current.ExpiresAt) {
+        return nil, ErrInvalidRefreshToken
+    }
+
+    user, err := models.GetUserByID(db, current.UserID)
+    if err != nil {
+        return nil, ErrInvalidRefreshToken
+    }
+    if user.IsSuspended(time.Now()) {
+        return nil, ErrAccountSuspended
+    }
+
+    // Logins from before sessions existed get one on their first refresh
+    session, err := models.GetSession(db, current.FamilyID)
+    if err == models.ErrSessionNotFound {
+        err = createSession(db, user.ID, current.FamilyID, r)
+    } else if err == nil && session.RevokedAt != nil {
+        return nil, ErrInvalidRefreshToken
+    } else if err == nil {
+        err = models.TouchSession(db, session.ID, time.Now())
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    next, nextRaw, err := newRefreshToken(user.ID, current.FamilyID)
+    if err != nil {
+        return nil, err
+    }
+    err = models.RotateRefreshToken(db, current, next)
+    if err == models.ErrRefreshTokenNotFound {
+        // Lost a race with another refresh using the same token
+        if err := models.RevokeRefreshTokenFamily(db, current.FamilyID); err != nil {
+            return nil, err
+        }
+        return nil, ErrInvalidRefreshToken
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return newTokenPair(user, current.FamilyID, nextRaw)
+}
+
+// RevokeRefreshToken ends the login the refresh token belongs to, if it belongs to userID
+func RevokeRefreshToken(db *sql.DB, userID int, raw string) error {
+    token, err := models.GetRefreshTokenByHash(db, HashToken(raw))
+    if err == models.ErrRefreshTokenNotFound {
+        return ErrInvalidRefreshToken
+    }
+    if err != nil {
+        return err
+    }
+    if token.UserID != userID {
+        return ErrInvalidRefreshToken
+    }
+    return models.RevokeRefreshTokenFamily(db, token.FamilyID)
+}
+
+func newRefreshToken(userID int, familyID string) (*models.RefreshToken, string, error) {
+    raw, err := GenerateRandomToken(32)
+    if err != nil {
+        return nil, "", err
+    }
+    return &models.RefreshToken{
+        UserID:    userID,
+        FamilyID:  familyID,
+        TokenHash: HashToken(raw),
+        ExpiresAt: time.Now().Add(refreshTokenTTL()),
+    }, raw, nil
+}
+
+func newTokenPair(user *models.User, sessionID, refreshToken string) (*TokenPair, error) {
+    accessToken, err := GenerateJWT(user, sessionID)
+    if err != nil {
+        return nil, err
+    }
+    return &TokenPair{
+        AccessToken:  accessToken,
+        RefreshToken: refreshToken,
+        TokenType:    "Bearer",
+        ExpiresIn:    int(accessTokenTTL().Seconds()),
+    }, nil
+}
+
+// CreateUserToken stores a new one-time token for purpose and returns the raw value to send to the user
+func CreateUserToken(db *sql.DB, userID int, purpose string, ttl time.Duration) (string, error) {
+    raw, err := GenerateRandomToken(32)
+    if err != nil {
+        return "", err
+    }
+
+    token := &models.UserToken{
+        UserID:    userID,
+        Purpose:   purpose,
+        TokenHash: HashToken(raw),
+        ExpiresAt: time.Now().Add(ttl),
+    }
+    if err := models.CreateUserToken(db, token); err != nil {
+        return "", err
+    }
+    return raw, nil
+}
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can't be mistaken for JWTs
+const PersonalAccessTokenPrefix = "blog_pat_"
+
+// GeneratePersonalAccessToken returns a new token and the short prefix shown in listings
+func GeneratePersonalAccessToken() (string, string, error) {
+    random, err := GenerateRandomToken(32)
+    if err != nil {
+        return "", "", err
+    }
+    token := PersonalAccessTokenPrefix + random
+    return token, token[:len(PersonalAccessTokenPrefix)+6], nil
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// rfc6238Secret is the SHA-1 key from RFC 6238 appendix B, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+    // The RFC lists 8-digit codes; 6-digit codes are their last six digits
+    tests := []struct {
+        unix int64
+        want string
+    }{
+        {59, "94287082"},
+        {1111111109, "07081804"},
+        {1111111111, "14050471"},
+        {1234567890, "89005924"},
+        {2000000000, "69279037"},
+        {20000000000, "65353130"},
+    }
+    for _, tt := range tests {
+        want := tt.want[len(tt.want)-totpDigits:]
+        key, err := totpEncoding.DecodeString(rfc6238Secret)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := totpCode(key, tt.unix/totpPeriod); got != want {
+            t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+        }
+
+        step, ok := ValidateTOTP(rfc6238Secret, want, time.Unix(tt.unix, 0))
+        if !ok || step != tt.unix/totpPeriod {
+            t.Errorf("ValidateTOTP at %d = (%d, %t), want (%d, true)", tt.unix, step, ok, tt.unix/totpPeriod)
+        }
+    }
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+    key, err := totpEncoding.DecodeString(rfc6238Secret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Unix(1234567890, 0)
+    current := now.Unix() / totpPeriod
+
+    tests := []struct {
+        name string
+        step int64
+        ok   bool
+    }{
+        {"current step", current, true},
+        {"previous step", current - 1, true},
+        {"next step", current + 1, true},
+        {"two steps ago", current - 2, false},
+        {"two steps ahead", current + 2, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            step, ok := ValidateTOTP(rfc6238Secret, totpCode(key, tt.step), now)
+            if ok != tt.ok {
+                t.Fatalf("ValidateTOTP = %t, want %t", ok, tt.ok)
+            }
+            // The matched step is what UseTOTPStep records against replays
+            if ok && step != tt.step {
+                t.Fatalf("matched step %d, want %d", step, tt.step)
+            }
+        })
+    }
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+    now := time.Unix(1234567890, 0)
+    key, err := totpEncoding.DecodeString(rfc6238Secret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    code := totpCode(key, now.Unix()/totpPeriod)
+
+    if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), code, now); !ok {
+        t.Error("lower-case secret was rejected")
+    }
+    if _, ok := ValidateTOTP(rfc6238Secret, code[:totpDigits-1], now); ok {
+        t.Error("short code was accepted")
+    }
+    if _, ok := ValidateTOTP(rfc6238Secret, code+"0", now); ok {
+        t.Error("long code was accepted")
+    }
+    if _, ok := ValidateTOTP("not base32!", code, now); ok {
+        t.Error("code for an invalid secret was accepted")
+    }
+}
@@ -0,0 +1,405 @@
+package utils
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "strings"
+)
+
+// COSE algorithm identifiers we accept for passkeys
+const (
+    COSEAlgES256 = -7
+    COSEAlgEdDSA = -8
+    COSEAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+    authDataUserPresent  = 0x01
+    authDataUserVerified = 0x04
+    authDataAttested     = 0x40
+)
+
+// WebAuthnConfig identifies this site (the relying party) to authenticators
+type WebAuthnConfig struct {
+    RPID    string
+    RPName  string
+    Origins []string
+    // DecoyKey derives the made-up credential IDs shown for accounts without passkeys
+    DecoyKey []byte
+}
+
+// WebAuthnConfigFromEnv reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME, the comma-separated WEBAUTHN_ORIGINS
+// and WEBAUTHN_DECOY_KEY. Without a decoy key a random one is used until the server restarts.
+func WebAuthnConfigFromEnv() WebAuthnConfig {
+    config := WebAuthnConfig{
+        RPID:     os.Getenv("WEBAUTHN_RP_ID"),
+        RPName:   os.Getenv("WEBAUTHN_RP_NAME"),
+        Origins:  []string{"http://localhost:8080"},
+        DecoyKey: []byte(os.Getenv("WEBAUTHN_DECOY_KEY")),
+    }
+    if len(config.DecoyKey) == 0 {
+        config.DecoyKey = make([]byte, 32)
+        if _, err := rand.Read(config.DecoyKey); err != nil {
+            panic(err)
+        }
+    }
+    if config.RPID == "" {
+        config.RPID = "localhost"
+    }
+    if config.RPName == "" {
+        config.RPName = "Blog API"
+    }
+    if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+        config.Origins = nil
+        for _, origin := range strings.Split(origins, ",") {
+            config.Origins = append(config.Origins, strings.TrimSpace(origin))
+        }
+    }
+    return config
+}
+
+// CredentialCreationResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.create()
+type CredentialCreationResponse struct {
+    ID       string `json:"id"`
+    RawID    string `json:"rawId"`
+    Type     string `json:"type"`
+    Response struct {
+        ClientDataJSON    string `json:"clientDataJSON"`
+        AttestationObject string `json:"attestationObject"`
+    } `json:"response"`
+}
+
+// CredentialAssertionResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.get()
+type CredentialAssertionResponse struct {
+    ID       string `json:"id"`
+    RawID    string `json:"rawId"`
+    Type     string `json:"type"`
+    Response struct {
+        ClientDataJSON    string `json:"clientDataJSON"`
+        AuthenticatorData string `json:"authenticatorData"`
+        Signature         string `json:"signature"`
+        UserHandle        string `json:"userHandle"`
+    } `json:"response"`
+}
+
+// CollectedClientData is the decoded clientDataJSON
+type CollectedClientData struct {
+    Type        string `json:"type"`
+    Challenge   string `json:"challenge"`
+    Origin      string `json:"origin"`
+    CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// RegisteredCredential is what needs storing after a successful registration
+type RegisteredCredential struct {
+    ID        []byte
+    PublicKey []byte // COSE_Key, as sent by the authenticator
+    SignCount uint32
+}
+
+type authenticatorData struct {
+    RPIDHash     []byte
+    Flags        byte
+    SignCount    uint32
+    CredentialID []byte
+    PublicKey    []byte
+}
+
+// DecoyCredentialIDs returns one or two made-up credential IDs for an email. They are
+// offered at login for emails with no passkeys, so that those look the same as accounts
+// that have some. Deriving them from the email keeps them stable across requests.
+func (c WebAuthnConfig) DecoyCredentialIDs(email string) [][]byte {
+    email = strings.ToLower(strings.TrimSpace(email))
+    mac := hmac.New(sha256.New, c.DecoyKey)
+    mac.Write([]byte(email))
+    seed := mac.Sum(nil)
+
+    ids := make([][]byte, 1+int(seed[0]%2))
+    for i := range ids {
+        mac := hmac.New(sha256.New, c.DecoyKey)
+        mac.Write([]byte{byte(i)})
+        mac.Write([]byte(email))
+        ids[i] = mac.Sum(nil)
+    }
+    return ids
+}
+
+// DecodeWebAuthnBase64 decodes the base64url fields of a credential response, with or without padding
+func DecodeWebAuthnBase64(s string) ([]byte, error) {
+    s = strings.TrimRight(s, "=")
+    if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+        return b, nil
+    }
+    return base64.RawStdEncoding.DecodeString(s)
+}
+
+// ParseClientData decodes clientDataJSON, returning the parsed value and the raw bytes
+func ParseClientData(encoded string) (*CollectedClientData, []byte, error) {
+    raw, err := DecodeWebAuthnBase64(encoded)
+    if err != nil {
+        return nil, nil, errors.New("clientDataJSON is not valid base64url")
+    }
+    var clientData CollectedClientData
+    if err := json.Unmarshal(raw, &clientData); err != nil {
+        return nil, nil, errors.New("clientDataJSON is not valid JSON")
+    }
+    return &clientData, raw, nil
+}
+
+// VerifyRegistration checks a navigator.credentials.create() response against the
+// challenge we issued. Attestation statements are not verified: we ask for
+// "none" attestation, since we don't restrict which authenticators may be used.
+func (c WebAuthnConfig) VerifyRegistration(resp *CredentialCreationResponse, challenge string) (*RegisteredCredential, error) {
+    clientData, _, err := ParseClientData(resp.Response.ClientDataJSON)
+    if err != nil {
+        return nil, err
+    }
+    if err := c.checkClientData(clientData, "webauthn.create", challenge); err != nil {
+        return nil, err
+    }
+
+    attestation, err := DecodeWebAuthnBase64(resp.Response.AttestationObject)
+    if err != nil {
+        return nil, errors.New("attestationObject is not valid base64url")
+    }
+    decoded, _, err := decodeCBOR(attestation)
+    if err != nil {
+        return nil, err
+    }
+    object, ok := decoded.(map[interface{}]interface{})
+    if !ok {
+        return nil, errors.New("attestationObject is not a map")
+    }
+    rawAuthData, ok := object["authData"].([]byte)
+    if !ok {
+        return nil, errors.New("attestationObject has no authData")
+    }
+
+    authData, err := parseAuthenticatorData(rawAuthData)
+    if err != nil {
+        return nil, err
+    }
+    if err := c.checkAuthenticatorData(authData); err != nil {
+        return nil, err
+    }
+    if authData.CredentialID == nil {
+        return nil, errors.New("authenticator data has no attested credential")
+    }
+
+    // Make sure we can use the key before storing it
+    if _, _, err := parseCOSEKey(authData.PublicKey); err != nil {
+        return nil, err
+    }
+
+    return &RegisteredCredential{
+        ID:        authData.CredentialID,
+        PublicKey: authData.PublicKey,
+        SignCount: authData.SignCount,
+    }, nil
+}
+
+// VerifyAssertion checks a navigator.credentials.get() response against the challenge
+// we issued and the stored credential. It returns the authenticator's new signature counter.
+func (c WebAuthnConfig) VerifyAssertion(resp *CredentialAssertionResponse, challenge string, publicKey []byte, storedSignCount uint32) (uint32, error) {
+    clientData, rawClientData, err := ParseClientData(resp.Response.ClientDataJSON)
+    if err != nil {
+        return 0, err
+    }
+    if err := c.checkClientData(clientData, "webauthn.get", challenge); err != nil {
+        return 0, err
+    }
+
+    rawAuthData, err := DecodeWebAuthnBase64(resp.Response.AuthenticatorData)
+    if err != nil {
+        return 0, errors.New("authenticatorData is not valid base64url")
+    }
+    authData, err := parseAuthenticatorData(rawAuthData)
+    if err != nil {
+        return 0, err
+    }
+    if err := c.checkAuthenticatorData(authData); err != nil {
+        return 0, err
+    }
+
+    signature, err := DecodeWebAuthnBase64(resp.Response.Signature)
+    if err != nil {
+        return 0, errors.New("signature is not valid base64url")
+    }
+
+    // The signature covers authenticatorData || SHA-256(clientDataJSON)
+    clientDataHash := sha256.Sum256(rawClientData)
+    signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+    if err := verifyCOSESignature(publicKey, signed, signature); err != nil {
+        return 0, err
+    }
+
+    // A counter that doesn't move forward suggests a cloned authenticator.
+    // Authenticators that don't implement counters always report 0.
+    if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+        return 0, errors.New("signature counter did not increase")
+    }
+
+    return authData.SignCount, nil
+}
+
+func (c WebAuthnConfig) checkClientData(clientData *CollectedClientData, ceremony, challenge string) error {
+    if clientData.Type != ceremony {
+        return fmt.Errorf("unexpected clientData type %q", clientData.Type)
+    }
+    if challenge == "" || clientData.Challenge != challenge {
+        return errors.New("challenge does not match")
+    }
+    for _, origin := range c.Origins {
+        if clientData.Origin == origin {
+            return nil
+        }
+    }
+    return fmt.Errorf("origin %q is not allowed", clientData.Origin)
+}
+
+func (c WebAuthnConfig) checkAuthenticatorData(authData *authenticatorData) error {
+    rpIDHash := sha256.Sum256([]byte(c.RPID))
+    if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+        return errors.New("credential was created for a different relying party")
+    }
+    if authData.Flags&authDataUserPresent == 0 {
+        return errors.New("user presence flag is not set")
+    }
+    // A passkey login skips the second factor, so the authenticator has to check a
+    // PIN or biometric too rather than just a touch
+    if authData.Flags&authDataUserVerified == 0 {
+        return errors.New("user verification flag is not set")
+    }
+    return nil
+}
+
+// ChallengeFromClientData returns the challenge echoed by the browser, so the
+// matching stored challenge can be looked up before full verification
+func ChallengeFromClientData(encoded string) (string, error) {
+    clientData, _, err := ParseClientData(encoded)
+    if err != nil {
+        return "", err
+    }
+    return clientData.Challenge, nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+    if len(data) < 37 {
+        return nil, errors.New("authenticator data is too short")
+    }
+    authData := &authenticatorData{
+        RPIDHash:  data[0:32],
+        Flags:     data[32],
+        SignCount: binary.BigEndian.Uint32(data[33:37]),
+    }
+
+    if authData.Flags&authDataAttested == 0 {
+        return authData, nil
+    }
+
+    // Attested credential data: AAGUID (16), credential ID length (2), credential ID, COSE key
+    rest := data[37:]
+    if len(rest) < 18 {
+        return nil, errors.New("attested credential data is too short")
+    }
+    idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+    rest = rest[18:]
+    if len(rest) < idLength {
+        return nil, errors.New("credential ID length exceeds data")
+    }
+    authData.CredentialID = rest[:idLength]
+    rest = rest[idLength:]
+
+    _, used, err := decodeCBOR(rest)
+    if err != nil {
+        return nil, err
+    }
+    authData.PublicKey = rest[:used]
+    return authData, nil
+}
+
+// parseCOSEKey converts a COSE_Key (RFC 9053) into a Go public key
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+    decoded, _, err := decodeCBOR(data)
+    if err != nil {
+        return nil, 0, err
+    }
+    key, ok := decoded.(map[interface{}]interface{})
+    if !ok {
+        return nil, 0, errors.New("COSE key is not a map")
+    }
+
+    kty, _ := key[int64(1)].(int64)
+    alg, _ := key[int64(3)].(int64)
+
+    switch {
+    case kty == 2 && alg == COSEAlgES256:
+        crv, _ := key[int64(-1)].(int64)
+        x, _ := key[int64(-2)].([]byte)
+        y, _ := key[int64(-3)].([]byte)
+        if crv != 1 || len(x) != 32 || len(y) != 32 {
+            return nil, 0, errors.New("invalid P-256 key")
+        }
+        pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+        if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+            return nil, 0, errors.New("P-256 point is not on the curve")
+        }
+        return pub, alg, nil
+    case kty == 1 && alg == COSEAlgEdDSA:
+        crv, _ := key[int64(-1)].(int64)
+        x, _ := key[int64(-2)].([]byte)
+        if crv != 6 || len(x) != ed25519.PublicKeySize {
+            return nil, 0, errors.New("invalid Ed25519 key")
+        }
+        return ed25519.PublicKey(x), alg, nil
+    case kty == 3 && alg == COSEAlgRS256:
+        n, _ := key[int64(-1)].([]byte)
+        e, _ := key[int64(-2)].([]byte)
+        if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+            return nil, 0, errors.New("invalid RSA key")
+        }
+        exponent := new(big.Int).SetBytes(e)
+        return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, alg, nil
+    }
+    return nil, 0, fmt.Errorf("unsupported COSE key (kty %d, alg %d)", kty, alg)
+}
+
+func verifyCOSESignature(coseKey, data, signature []byte) error {
+    pub, alg, err := parseCOSEKey(coseKey)
+    if err != nil {
+        return err
+    }
+
+    switch alg {
+    case COSEAlgES256:
+        digest := sha256.Sum256(data)
+        if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signature) {
+            return errors.New("invalid signature")
+        }
+    case COSEAlgEdDSA:
+        if !ed25519.Verify(pub.(ed25519.PublicKey), data, signature) {
+            return errors.New("invalid signature")
+        }
+    case COSEAlgRS256:
+        digest := sha256.Sum256(data)
+        if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+            return errors.New("invalid signature")
+        }
+    }
+    return nil
+}
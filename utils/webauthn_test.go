@@ -0,0 +1,435 @@
+package utils
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+// cborPair keeps map entries in a fixed order when encoding
+type cborPair struct {
+    key   interface{}
+    value interface{}
+}
+
+type cborMap []cborPair
+
+// encodeCBOR is just enough of a CBOR encoder to build authenticator responses
+func encodeCBOR(v interface{}) []byte {
+    switch v := v.(type) {
+    case int:
+        if v < 0 {
+            return cborHead(1, uint64(-1-v))
+        }
+        return cborHead(0, uint64(v))
+    case []byte:
+        return append(cborHead(2, uint64(len(v))), v...)
+    case string:
+        return append(cborHead(3, uint64(len(v))), v...)
+    case []interface{}:
+        out := cborHead(4, uint64(len(v)))
+        for _, item := range v {
+            out = append(out, encodeCBOR(item)...)
+        }
+        return out
+    case cborMap:
+        out := cborHead(5, uint64(len(v)))
+        for _, pair := range v {
+            out = append(out, encodeCBOR(pair.key)...)
+            out = append(out, encodeCBOR(pair.value)...)
+        }
+        return out
+    }
+    panic("encodeCBOR: unsupported type")
+}
+
+func cborHead(major byte, arg uint64) []byte {
+    switch {
+    case arg < 24:
+        return []byte{major<<5 | byte(arg)}
+    case arg <= 0xff:
+        return []byte{major<<5 | 24, byte(arg)}
+    case arg <= 0xffff:
+        return []byte{major<<5 | 25, byte(arg >> 8), byte(arg)}
+    }
+    b := []byte{major<<5 | 26, 0, 0, 0, 0}
+    binary.BigEndian.PutUint32(b[1:], uint32(arg))
+    return b
+}
+
+// softwareAuthenticator plays the part of a security key or platform authenticator
+type softwareAuthenticator struct {
+    credentialID []byte
+    signer       crypto.Signer
+    signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T, alg int) *softwareAuthenticator {
+    t.Helper()
+    var signer crypto.Signer
+    var err error
+    if alg == COSEAlgEdDSA {
+        _, signer, err = ed25519.GenerateKey(rand.Reader)
+    } else {
+        signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    id := make([]byte, 16)
+    rand.Read(id)
+    return &softwareAuthenticator{credentialID: id, signer: signer}
+}
+
+func (a *softwareAuthenticator) coseKey() []byte {
+    switch pub := a.signer.Public().(type) {
+    case *ecdsa.PublicKey:
+        x := make([]byte, 32)
+        y := make([]byte, 32)
+        pub.X.FillBytes(x)
+        pub.Y.FillBytes(y)
+        return encodeCBOR(cborMap{{1, 2}, {3, COSEAlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+    case ed25519.PublicKey:
+        return encodeCBOR(cborMap{{1, 1}, {3, COSEAlgEdDSA}, {-1, 6}, {-2, []byte(pub)}})
+    }
+    panic("unsupported key")
+}
+
+func (a *softwareAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+    rpIDHash := sha256.Sum256([]byte(rpID))
+    data := append([]byte{}, rpIDHash[:]...)
+    data = append(data, flags)
+    data = binary.BigEndian.AppendUint32(data, a.signCount)
+    if attested {
+        data = append(data, make([]byte, 16)...) // AAGUID
+        data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+        data = append(data, a.credentialID...)
+        data = append(data, a.coseKey()...)
+    }
+    return data
+}
+
+func (a *softwareAuthenticator) sign(data []byte) []byte {
+    var signature []byte
+    var err error
+    if _, ok := a.signer.(ed25519.PrivateKey); ok {
+        signature, err = a.signer.Sign(rand.Reader, data, crypto.Hash(0))
+    } else {
+        digest := sha256.Sum256(data)
+        signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+    }
+    if err != nil {
+        panic(err)
+    }
+    return signature
+}
+
+func clientDataJSON(ceremony, challenge, origin string) string {
+    raw, _ := json.Marshal(CollectedClientData{Type: ceremony, Challenge: challenge, Origin: origin})
+    return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ceremony describes what the authenticator and browser put into a response, so each
+// test case can change one thing from a valid response
+type ceremony struct {
+    rpID      string
+    origin    string
+    challenge string
+    flags     byte
+}
+
+var testWebAuthnConfig = WebAuthnConfig{RPID: "example.com", Origins: []string{"https://example.com"}}
+
+const testChallenge = "server-issued-challenge"
+
+func validCeremony() ceremony {
+    return ceremony{rpID: "example.com", origin: "https://example.com", challenge: testChallenge, flags: authDataUserPresent | authDataUserVerified}
+}
+
+func (a *softwareAuthenticator) register(c ceremony) *CredentialCreationResponse {
+    attestation := encodeCBOR(cborMap{
+        {"fmt", "none"},
+        {"attStmt", cborMap{}},
+        {"authData", a.authData(c.rpID, c.flags|authDataAttested, true)},
+    })
+    resp := &CredentialCreationResponse{
+        ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+        RawID: base64.RawURLEncoding.EncodeToString(a.credentialID),
+        Type:  "public-key",
+    }
+    resp.Response.ClientDataJSON = clientDataJSON("webauthn.create", c.challenge, c.origin)
+    resp.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attestation)
+    return resp
+}
+
+func (a *softwareAuthenticator) assert(c ceremony) *CredentialAssertionResponse {
+    a.signCount++
+    authData := a.authData(c.rpID, c.flags, false)
+    resp := &CredentialAssertionResponse{
+        ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+        RawID: base64.RawURLEncoding.EncodeToString(a.credentialID),
+        Type:  "public-key",
+    }
+    resp.Response.ClientDataJSON = clientDataJSON("webauthn.get", c.challenge, c.origin)
+    rawClientData, _ := base64.RawURLEncoding.DecodeString(resp.Response.ClientDataJSON)
+    clientDataHash := sha256.Sum256(rawClientData)
+    resp.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+    resp.Response.Signature = base64.RawURLEncoding.EncodeToString(a.sign(append(authData, clientDataHash[:]...)))
+    return resp
+}
+
+var algorithms = []struct {
+    name string
+    alg  int
+}{
+    {"ES256", COSEAlgES256},
+    {"EdDSA", COSEAlgEdDSA},
+}
+
+func TestVerifyRegistration(t *testing.T) {
+    tests := []struct {
+        name    string
+        change  func(c *ceremony)
+        wantErr string
+    }{
+        {"valid", func(c *ceremony) {}, ""},
+        {"wrong origin", func(c *ceremony) { c.origin = "https://evil.example" }, "origin"},
+        {"wrong rpIdHash", func(c *ceremony) { c.rpID = "evil.example" }, "relying party"},
+        {"wrong challenge", func(c *ceremony) { c.challenge = "another-challenge" }, "challenge"},
+        {"missing UP flag", func(c *ceremony) { c.flags = 0 }, "user presence"},
+        {"missing UV flag", func(c *ceremony) { c.flags = authDataUserPresent }, "user verification"},
+    }
+
+    for _, algorithm := range algorithms {
+        for _, tt := range tests {
+            t.Run(algorithm.name+"/"+tt.name, func(t *testing.T) {
+                authenticator := newSoftwareAuthenticator(t, algorithm.alg)
+                c := validCeremony()
+                tt.change(&c)
+
+                credential, err := testWebAuthnConfig.VerifyRegistration(authenticator.register(c), testChallenge)
+                if tt.wantErr != "" {
+                    if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                        t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+                    }
+                    return
+                }
+                if err != nil {
+                    t.Fatalf("unexpected error: %v", err)
+                }
+                if !bytes.Equal(credential.ID, authenticator.credentialID) {
+                    t.Errorf("credential ID = %x, want %x", credential.ID, authenticator.credentialID)
+                }
+                if !bytes.Equal(credential.PublicKey, authenticator.coseKey()) {
+                    t.Errorf("stored public key doesn't match the authenticator's")
+                }
+            })
+        }
+    }
+}
+
+func TestVerifyRegistrationRejectsAssertionClientData(t *testing.T) {
+    authenticator := newSoftwareAuthenticator(t, COSEAlgES256)
+    resp := authenticator.register(validCeremony())
+    resp.Response.ClientDataJSON = clientDataJSON("webauthn.get", testChallenge, "https://example.com")
+
+    _, err := testWebAuthnConfig.VerifyRegistration(resp, testChallenge)
+    if err == nil || !strings.Contains(err.Error(), "clientData type") {
+        t.Fatalf("got error %v, want a clientData type error", err)
+    }
+}
+
+func TestVerifyAssertion(t *testing.T) {
+    tests := []struct {
+        name string
+        // storedCount is the counter saved from the previous login
+        storedCount func(a *softwareAuthenticator) uint32
+        change      func(c *ceremony)
+        tamper      func(resp *CredentialAssertionResponse)
+        wantErr     string
+    }{
+        {name: "valid"},
+        {name: "wrong origin", change: func(c *ceremony) { c.origin = "https://evil.example" }, wantErr: "origin"},
+        {name: "wrong rpIdHash", change: func(c *ceremony) { c.rpID = "evil.example" }, wantErr: "relying party"},
+        {name: "wrong challenge", change: func(c *ceremony) { c.challenge = "another-challenge" }, wantErr: "challenge"},
+        {name: "missing UP flag", change: func(c *ceremony) { c.flags = 0 }, wantErr: "user presence"},
+        {name: "touch without user verification", change: func(c *ceremony) { c.flags = authDataUserPresent }, wantErr: "user verification"},
+        {
+            name:        "sign count not increasing",
+            storedCount: func(a *softwareAuthenticator) uint32 { return a.signCount + 5 },
+            wantErr:     "counter",
+        },
+        {
+            name: "bad signature",
+            tamper: func(resp *CredentialAssertionResponse) {
+                signature, _ := base64.RawURLEncoding.DecodeString(resp.Response.Signature)
+                signature[len(signature)-1] ^= 0x01
+                resp.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+            },
+            wantErr: "signature",
+        },
+        {
+            name: "client data changed after signing",
+            tamper: func(resp *CredentialAssertionResponse) {
+                raw, _ := base64.RawURLEncoding.DecodeString(resp.Response.ClientDataJSON)
+                raw = append(raw[:len(raw)-1], []byte(`,"crossOrigin":false}`)...)
+                resp.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(raw)
+            },
+            wantErr: "signature",
+        },
+    }
+
+    for _, algorithm := range algorithms {
+        for _, tt := range tests {
+            t.Run(algorithm.name+"/"+tt.name, func(t *testing.T) {
+                authenticator := newSoftwareAuthenticator(t, algorithm.alg)
+                authenticator.signCount = 10
+                storedCount := authenticator.signCount
+                if tt.storedCount != nil {
+                    storedCount = tt.storedCount(authenticator)
+                }
+
+                c := validCeremony()
+                if tt.change != nil {
+                    tt.change(&c)
+                }
+                resp := authenticator.assert(c)
+                if tt.tamper != nil {
+                    tt.tamper(resp)
+                }
+
+                signCount, err := testWebAuthnConfig.VerifyAssertion(resp, testChallenge, authenticator.coseKey(), storedCount)
+                if tt.wantErr != "" {
+                    if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                        t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+                    }
+                    return
+                }
+                if err != nil {
+                    t.Fatalf("unexpected error: %v", err)
+                }
+                if signCount != authenticator.signCount {
+                    t.Errorf("sign count = %d, want %d", signCount, authenticator.signCount)
+                }
+            })
+        }
+    }
+}
+
+func TestVerifyAssertionAllowsAuthenticatorsWithoutCounter(t *testing.T) {
+    authenticator := newSoftwareAuthenticator(t, COSEAlgES256)
+    resp := authenticator.assert(validCeremony())
+    // Authenticators without a counter always send 0
+    authData, _ := base64.RawURLEncoding.DecodeString(resp.Response.AuthenticatorData)
+    binary.BigEndian.PutUint32(authData[33:37], 0)
+    resp.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+    rawClientData, _ := base64.RawURLEncoding.DecodeString(resp.Response.ClientDataJSON)
+    clientDataHash := sha256.Sum256(rawClientData)
+    resp.Response.Signature = base64.RawURLEncoding.EncodeToString(authenticator.sign(append(authData, clientDataHash[:]...)))
+
+    _, err := testWebAuthnConfig.VerifyAssertion(resp, testChallenge, authenticator.coseKey(), 0)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+}
+
+func TestVerifyAssertionRejectsOtherKey(t *testing.T) {
+    authenticator := newSoftwareAuthenticator(t, COSEAlgES256)
+    other := newSoftwareAuthenticator(t, COSEAlgES256)
+
+    _, err := testWebAuthnConfig.VerifyAssertion(authenticator.assert(validCeremony()), testChallenge, other.coseKey(), 0)
+    if err == nil || !strings.Contains(err.Error(), "signature") {
+        t.Fatalf("got error %v, want a signature error", err)
+    }
+}
+
+func TestDecodeCBOR(t *testing.T) {
+    data := encodeCBOR(cborMap{
+        {1, 2},
+        {-1, []byte{0xaa}},
+        {"list", []interface{}{"a", 500}},
+    })
+    // Trailing bytes belong to whatever follows the item
+    decoded, used, err := decodeCBOR(append(data, 0xff))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if used != len(data) {
+        t.Errorf("used %d bytes, want the whole item", used)
+    }
+    m := decoded.(map[interface{}]interface{})
+    if m[int64(1)] != int64(2) || !bytes.Equal(m[int64(-1)].([]byte), []byte{0xaa}) {
+        t.Errorf("decoded %v", m)
+    }
+    list := m["list"].([]interface{})
+    if len(list) != 2 || list[0] != "a" || list[1] != int64(500) {
+        t.Errorf("decoded list %v", list)
+    }
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+    // Arrays nested one level deeper than allowed
+    tooDeep := append(bytes.Repeat([]byte{0x81}, maxCBORDepth+1), 0x00)
+    // Arrays nested exactly as deep as allowed still decode
+    deepEnough := append(bytes.Repeat([]byte{0x81}, maxCBORDepth), 0x00)
+
+    tests := []struct {
+        name    string
+        data    []byte
+        wantErr string
+    }{
+        {"empty", nil, "unexpected end"},
+        {"too deep", tooDeep, "too deep"},
+        {"byte string longer than data", []byte{0x45, 0x01, 0x02}, "length exceeds"},
+        {"text string longer than data", []byte{0x78, 0xff, 'a'}, "length exceeds"},
+        {"huge byte string length", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "length exceeds"},
+        {"array longer than data", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}, "length exceeds"},
+        {"map longer than data", []byte{0xba, 0xff, 0xff, 0xff, 0xff}, "length exceeds"},
+        {"truncated array", []byte{0x83, 0x01, 0x02}, "unexpected end"},
+        {"truncated argument", []byte{0x19, 0x01}, "unexpected end"},
+        {"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}, "indefinite"},
+        {"unsupported map key", []byte{0xa1, 0x41, 0x00, 0x00}, "map key"},
+        {"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "overflow"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, err := decodeCBOR(tt.data)
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("got error %v, want one mentioning %q", err, tt.wantErr)
+            }
+        })
+    }
+
+    if _, _, err := decodeCBOR(deepEnough); err != nil {
+        t.Errorf("nesting at the limit: unexpected error %v", err)
+    }
+}
+
+func TestDecoyCredentialIDs(t *testing.T) {
+    config := WebAuthnConfig{DecoyKey: []byte("test key")}
+
+    first := config.DecoyCredentialIDs("Someone@Example.com ")
+    again := config.DecoyCredentialIDs("someone@example.com")
+    if len(first) == 0 || len(first) != len(again) {
+        t.Fatalf("got %d and %d decoys, want the same non-zero number", len(first), len(again))
+    }
+    for i := range first {
+        if !bytes.Equal(first[i], again[i]) {
+            t.Errorf("decoy %d differs between requests for the same email", i)
+        }
+    }
+
+    other := WebAuthnConfig{DecoyKey: []byte("other key")}.DecoyCredentialIDs("someone@example.com")
+    if bytes.Equal(first[0], other[0]) {
+        t.Errorf("decoys don't depend on the key")
+    }
+}
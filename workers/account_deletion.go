@@ -0,0 +1,72 @@
+package workers
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+// StartAccountDeletionWorker deletes accounts whose grace period has passed, checking
+// every interval. It runs until the process exits.
+func StartAccountDeletionWorker(db *sql.DB, searcher utils.Searcher, interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            processAccountDeletions(db, searcher, time.Now())
+            <-ticker.C
+        }
+    }()
+}
+
+func processAccountDeletions(db *sql.DB, searcher utils.Searcher, now time.Time) {
+    deletions, err := models.GetDueAccountDeletions(db, now)
+    if err != nil {
+        fmt.Println("Error fetching account deletions:", err)
+        return
+    }
+
+    for _, deletion := range deletions {
+        // Read before deleting, so the audit entry can say whose account it was
+        user, err := models.GetUserByID(db, deletion.UserID)
+        if err != nil {
+            fmt.Println("Error fetching user for deletion:", err)
+            continue
+        }
+
+        posts, err := models.DeleteAccount(db, deletion.UserID, deletion.Mode)
+        if err != nil {
+            fmt.Println("Error deleting account:", err)
+            continue
+        }
+        if deletion.Mode == models.DeletionModeDelete {
+            for _, postID := range posts {
+                err = searcher.RemovePost(postID)
+                if err != nil {
+                    fmt.Println("Error removing post from search index:", err)
+                }
+            }
+        }
+
+        userID := deletion.UserID
+        err = models.RecordAudit(db, &models.AuditEntry{
+            Action:       models.AuditAccountDeleted,
+            TargetUserID: &userID,
+            Details: fmt.Sprintf("deleted account %q requested at %s; %d posts %s",
+                user.Username, deletion.RequestedAt.Format(time.RFC3339), len(posts), postOutcome(deletion.Mode)),
+        })
+        if err != nil {
+            fmt.Println("Error writing audit log:", err)
+        }
+        fmt.Printf("Deleted account %d (%s)\n", deletion.UserID, deletion.Mode)
+    }
+}
+
+func postOutcome(mode string) string {
+    if mode == models.DeletionModeDelete {
+        return "deleted"
+    }
+    return "moved to the anonymous author"
+}
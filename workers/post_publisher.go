@@ -0,0 +1,47 @@
+package workers
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+    "blog-app/models"
+    "blog-app/utils"
+)
+
+// StartPostPublisher publishes scheduled posts once their publish_at time has passed,
+// checking every interval. It runs until the process exits.
+func StartPostPublisher(db *sql.DB, searcher utils.Searcher, interval time.Duration) {
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            publishDuePosts(db, searcher, time.Now())
+            <-ticker.C
+        }
+    }()
+}
+
+func publishDuePosts(db *sql.DB, searcher utils.Searcher, now time.Time) {
+    ids, err := models.PublishDuePosts(db, now)
+    if err != nil {
+        fmt.Println("Error publishing scheduled posts:", err)
+        return
+    }
+    if len(ids) == 0 {
+        return
+    }
+
+    // Newly published posts become searchable
+    posts, err := models.GetPostsByIDs(db, ids)
+    if err != nil {
+        fmt.Println("Error fetching published posts:", err)
+        return
+    }
+    for i := range posts {
+        err = searcher.IndexPost(&posts[i])
+        if err != nil {
+            fmt.Println("Error indexing post:", err)
+        }
+    }
+    fmt.Printf("Published %d scheduled posts\n", len(ids))
+}